@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/Clever/kayvee-go/logger"
+	whoswho "github.com/Clever/who-is-who/go-client"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// teamForAlias returns the team that name is an alias of, if any
+func (bot *Bot) teamForAlias(name string) (string, bool) {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+
+	team, ok := bot.State.Aliases[name]
+	return team, ok
+}
+
+// teamNamed returns the team with name as its name or one of its aliases. Unlike findMatchingTeam, it doesn't
+// guess at near misses.
+func (bot *Bot) teamNamed(name string) (string, bool) {
+	if team, ok := bot.teamForAlias(name); ok {
+		return team, true
+	}
+	if containsString(bot.knownTeams(), name) {
+		return name, true
+	}
+	return "", false
+}
+
+// aliasesForTeam returns every alias of team
+func (bot *Bot) aliasesForTeam(team string) []string {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+
+	aliases := []string{}
+	for alias, t := range bot.State.Aliases {
+		if t == team {
+			aliases = append(aliases, alias)
+		}
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+func (bot *Bot) addAlias(ev *slackevents.MessageEvent, teamName, alias string) {
+	bot.Logger.InfoD("add-alias", logger.M{"team": teamName, "alias": alias, "user": ev.User})
+
+	actualTeamName, err := bot.findMatchingTeam(teamName)
+	if err != nil {
+		bot.reportTeamLookupError(ev, err, func(team string) {
+			bot.addAlias(ev, team, alias)
+		})
+		return
+	}
+
+	var text string
+	if alias == actualTeamName {
+		text = fmt.Sprintf("%s is already called %s", actualTeamName, alias)
+	} else if _, isAlias := bot.teamForAlias(alias); !isAlias && containsString(bot.knownTeams(), alias) {
+		text = fmt.Sprintf("Sorry, there's already a team called %s", alias)
+	} else if err := bot.updateState(func(state *botState) {
+		state.Aliases[alias] = actualTeamName
+	}); err != nil {
+		bot.Logger.ErrorD("add-alias-error", logger.M{"error": err.Error(), "event-text": ev.Text})
+		text = fmt.Sprintf("Sorry, I couldn't save that alias: %s", err)
+	} else {
+		text = fmt.Sprintf("OK, %s now means team %s", alias, actualTeamName)
+	}
+
+	err = bot.SlackEventsService.PostMessage(ev.Channel, text)
+	if err != nil {
+		bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
+	}
+}
+
+func (bot *Bot) removeAlias(ev *slackevents.MessageEvent, alias string) {
+	bot.Logger.InfoD("remove-alias", logger.M{"alias": alias, "user": ev.User})
+
+	var text string
+	if _, ok := bot.teamForAlias(alias); !ok {
+		text = fmt.Sprintf("Sorry, %s isn't an alias", alias)
+	} else if err := bot.updateState(func(state *botState) {
+		delete(state.Aliases, alias)
+	}); err != nil {
+		bot.Logger.ErrorD("remove-alias-error", logger.M{"error": err.Error(), "event-text": ev.Text})
+		text = fmt.Sprintf("Sorry, I couldn't remove that alias: %s", err)
+	} else {
+		text = fmt.Sprintf("OK, %s is no longer an alias", alias)
+	}
+
+	err := bot.SlackEventsService.PostMessage(ev.Channel, text)
+	if err != nil {
+		bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
+	}
+}
+
+// detectTeamRenames guesses which teams were renamed between two sets of teams.
+// A team that disappeared was renamed if more than half of its members are on a single new team.
+func detectTeamRenames(before, after map[string][]whoswho.User) map[string]string {
+	renames := map[string]string{}
+	for oldTeam, oldMembers := range before {
+		if _, ok := after[oldTeam]; ok || len(oldMembers) == 0 {
+			continue
+		}
+
+		oldIDs := map[string]struct{}{}
+		for _, u := range oldMembers {
+			oldIDs[u.SlackID] = struct{}{}
+		}
+
+		bestTeam, bestOverlap := "", 0
+		for newTeam, newMembers := range after {
+			if _, ok := before[newTeam]; ok {
+				continue
+			}
+			overlap := 0
+			for _, u := range newMembers {
+				if _, ok := oldIDs[u.SlackID]; ok {
+					overlap++
+				}
+			}
+			if overlap > bestOverlap || (overlap == bestOverlap && newTeam < bestTeam) {
+				bestTeam, bestOverlap = newTeam, overlap
+			}
+		}
+
+		if bestOverlap*2 > len(oldIDs) {
+			renames[oldTeam] = bestTeam
+		}
+	}
+	return renames
+}
+
+// aliasRenamedTeams keeps each renamed team's old name working as an alias, and returns a notice for each.
+// The team's settings, and composite teams made of it, move to its new name.
+func (bot *Bot) aliasRenamedTeams(renames map[string]string) []string {
+	oldTeams := []string{}
+	for oldTeam := range renames {
+		oldTeams = append(oldTeams, oldTeam)
+	}
+	sort.Strings(oldTeams)
+
+	notices := []string{}
+	for _, oldTeam := range oldTeams {
+		newTeam := renames[oldTeam]
+		bot.Logger.InfoD("team-renamed", logger.M{"old-team": oldTeam, "new-team": newTeam})
+
+		err := bot.updateState(func(state *botState) {
+			state.Aliases[oldTeam] = newTeam
+			// aliases of the old name follow the team too
+			for alias, team := range state.Aliases {
+				if team == oldTeam {
+					state.Aliases[alias] = newTeam
+				}
+			}
+			// and its settings, unless the new name already has its own
+			if settings, ok := state.TeamSettings[oldTeam]; ok {
+				if _, ok := state.TeamSettings[newTeam]; !ok {
+					state.TeamSettings[newTeam] = settings
+				}
+				delete(state.TeamSettings, oldTeam)
+			}
+			// and composite teams made of it
+			for composite, members := range state.Composites {
+				renamed := []string{}
+				for _, member := range members {
+					if member == oldTeam {
+						member = newTeam
+					}
+					if !containsString(renamed, member) {
+						renamed = append(renamed, member)
+					}
+				}
+				state.Composites[composite] = renamed
+			}
+		})
+		if err != nil {
+			bot.Logger.ErrorD("team-renamed-alias-error", logger.M{"old-team": oldTeam, "new-team": newTeam, "error": err.Error()})
+			notices = append(notices, fmt.Sprintf("Team %s was renamed to %s in who-is-who, but I couldn't save %s as an alias", oldTeam, newTeam, oldTeam))
+			continue
+		}
+		notices = append(notices, fmt.Sprintf("Team %s was renamed to %s in who-is-who. %s still works as an alias", oldTeam, newTeam, oldTeam))
+	}
+	return notices
+}
@@ -8,6 +8,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Clever/kayvee-go/logger"
@@ -28,58 +29,145 @@ type Bot struct {
 	Logger  logger.KayveeLogger
 	DevMode bool
 
-	GithubClient       github.AppClientIface
-	GithubOrgName      string
 	SlackAPIService    slackapi.SlackAPIService
 	SlackEventsService slackapi.SlackEventsService
 
+	GithubClient github.AppClientIface
+	// GithubOrgNames are the orgs whose pull requests can be assigned
+	GithubOrgNames []string
+	// GithubWebHost is the host pull request links are on, default github.com
+	GithubWebHost string
+
 	// TODO: Move all picking logic to a separate struct{}
-	UserFlair         map[string]string
-	TeamToTeamMembers map[string][]whoswho.User
-	TeamOverrides     []Override
-	RandomSource      rand.Source
-	WhoIsWhoClient    whoIsWhoClientIface
-	LastCacheRefresh  time.Time
+	RandomSource rand.Source
+	Directory    directoryProvider
+	// cachedTeams holds the current *teamCache. Read it with cache() and change it with updateCache().
+	cachedTeams atomic.Pointer[teamCache]
+
+	// SnapshotPath is where the last directory data that loaded successfully is saved, for when the directory is down
+	SnapshotPath string
+	// RefreshInterval is how often the cache is refreshed, default defaultRefreshInterval
+	RefreshInterval time.Duration
+	// StaleAfter is how old cached data gets before a warning is posted, default defaultStaleAfter
+	StaleAfter time.Duration
+
+	// SyncDirectory copies override and flair changes to the directory. pickabot's own state is their system of record.
+	SyncDirectory bool
+
+	// AdminSlackIDs may change any team's membership, not just teams they belong to
+	AdminSlackIDs []string
+	// NotificationChannel receives notices about changes the bot noticed on its own, e.g. renamed teams
+	NotificationChannel string
+
+	// WebhookSecret signs GitHub webhooks. Without it, every webhook is rejected.
+	WebhookSecret []byte
+	// WebhookChannel is told about reviewers picked because of webhooks
+	WebhookChannel string
+
+	State      botState
+	StateStore stateStore
+	// pickHistorySave is the pending save of picks recordPicks hasn't saved yet, if there are any
+	pickHistorySave *time.Timer
+
+	// StartTime is when the bot started, for its uptime
+	StartTime time.Time
+	// Version is the version of pickabot that's running
+	Version string
+	// lastRefresh holds the *refreshResult of the most recent refresh, whether or not it worked
+	lastRefresh atomic.Pointer[refreshResult]
+	// slackConnection holds the state of the Slack socket mode connection, e.g. "connected"
+	slackConnection atomic.Value
+
+	undoHistory      map[string][]undoEntry
+	teamChoices      map[string]pendingTeamChoice
+	lastTeamChoiceID int64
+
+	// webhookDeliveries are the IDs of recent webhooks, oldest first in webhookDeliveryOrder
+	webhookDeliveries    map[string]struct{}
+	webhookDeliveryOrder []string
+	// webhooks tracks webhooks that are still being handled
+	webhooks sync.WaitGroup
 }
 
-const teamMatcher = `#?(eng)?[- ]?([a-zA-Z-]+)`
+// githubHost is the host pull request links are on
+func (bot *Bot) githubHost() string {
+	if bot.GithubWebHost == "" {
+		return "github.com"
+	}
+	return bot.GithubWebHost
+}
+
+const teamMatcher = `#?(eng)?[- ]?([a-zA-Z/-]+)`
 const individualMatcher = `<@([a-zA-Z0-9-]+)>`
 
+// usersMatcher and teamsMatcher match lists such as "<@U1> <@U2> and <@U3>" or "infra, data and platform"
+const usersMatcher = `((?:<@[a-zA-Z0-9-]+>[\s,]*(?:and\s+)?)+)`
+const teamsMatcher = `((?:#?(?:eng)?[- ]?[a-zA-Z/-]+)(?:\s*(?:,|&|\band\b)\s*#?(?:eng)?[- ]?[a-zA-Z/-]+)*)`
+
 var botMessageRegex = regexp.MustCompile(`^<@(.+?)> (.*)`)
 var pickTeamRegex = regexp.MustCompile(`^\s*(pick\ and\ assign|pick|assign)\s*[a]?[n]?\s*` + teamMatcher)
 var pickIndividualRegex = regexp.MustCompile(`^\s*(pick\ and\ assign|pick|assign)\s*[a]?[n]?\s*` + individualMatcher)
 var listTeamRegex = regexp.MustCompile(`^\s*who is\s*[ai]?[n]?\s*` + teamMatcher)
-var overrideTeamRegex = regexp.MustCompile(`^\s*<@(.+?)> is\s*(not)?\s*[a]?[n]? ` + teamMatcher)
-var overrideTeamRegex2 = regexp.MustCompile(`^\s*(add|remove)\s+<@(.+?)>\s+(to|from)\s+` + teamMatcher)
+var overrideTeamRegex = regexp.MustCompile(`^\s*` + usersMatcher + `\s*(?:is|are)\s*(not)?\s*[a]?[n]? ` + teamsMatcher)
+var overrideTeamRegex2 = regexp.MustCompile(`^\s*(add|remove)\s+` + usersMatcher + `\s*(to|from)\s+` + teamsMatcher)
+var moveTeamRegex = regexp.MustCompile(`^\s*move\s+` + usersMatcher + `\s*from\s+` + teamsMatcher + `\s+to\s+` + teamsMatcher)
+var individualRegex = regexp.MustCompile(individualMatcher)
+var teamListSeparatorRegex = regexp.MustCompile(`\s*(?:,|&|\band\b)\s*`)
+var teamRegex = regexp.MustCompile(`^` + teamMatcher + `$`)
 var addFlairRegex = regexp.MustCompile(`^\s*add flair (.*)`)
 var removeFlairRegex = regexp.MustCompile(`^\s*remove flair`)
 var setAssigneeRegex = regexp.MustCompile(`.*assign.*`)
 var helpRegex = regexp.MustCompile(`^\s*help`)
 var refreshCacheRegex = regexp.MustCompile(`^\s*refresh`)
+var userTeamsRegex = regexp.MustCompile(`^\s*what teams (?:is|are)\s+` + individualMatcher + `\s+(?:on|in)`)
+var whoamiRegex = regexp.MustCompile(`^\s*(?:whoami|who am i|what teams am i (?:on|in))`)
+var listTeamsRegex = regexp.MustCompile(`^\s*list\s+(?:all\s+)?teams`)
+var undoRegex = regexp.MustCompile(`^\s*undo\s*$`)
+var addAliasRegex = regexp.MustCompile(`^\s*alias\s+` + teamMatcher + `\s+as\s+([a-zA-Z/-]+)`)
+var removeAliasRegex = regexp.MustCompile(`^\s*unalias\s+([a-zA-Z/-]+)`)
+var defineCompositeRegex = regexp.MustCompile(`^\s*(?:define\s+)?([a-zA-Z/-]+)\s*=\s*(.+)`)
+var removeCompositeRegex = regexp.MustCompile(`^\s*undefine\s+([a-zA-Z/-]+)`)
+var configureTeamRegex = regexp.MustCompile(`^\s*configure\s+` + teamMatcher + `\s*(.*)`)
+var statusRegex = regexp.MustCompile(`^\s*status\s*$`)
+var assignFromRepoConfigRegex = regexp.MustCompile(`^\s*(?:pick\s+and\s+)?assign\s+(?:for\s+)?(?:<?https?://|[\w.-]+(?:/[\w.-]+)?#\d)`)
 
 const didNotUnderstand = "Sorry, I didn't understand that"
 const couldNotFindTeam = "Sorry, I couldn't find a team with that name"
 const pickUserProblem = "Sorry, I ran into an issue picking a user. Check my logs for more details :sleuth_or_spy:"
+const couldNotSave = "Sorry, I couldn't save that change: %s"
+const notAllowedToModifyTeam = "Sorry, only members of team %s, the user being changed, or a pickabot admin can change that team's members"
 const helpMessage = "_Pika-pi!_\n\nI can do the following:\n\n" +
 	"`@pickabot pick a <team>` - picks a user from that team\n" +
 	"`@pickabot assign a <team> for <Github PR URL(s)>` - assigns a user from that team to the Github PR(s)\n" +
+	"`@pickabot assign for <Github PR URL(s)>` - assigns reviewers as the repo's `.pickabot.yml` says\n" +
 	"`@pickabot who is <team>` - lists users who belong to that team\n" +
+	"`@pickabot list teams` - lists all teams and how many members they have\n" +
+	"`@pickabot what teams is @user on` - shows a user's teams, overrides, flair and GitHub login\n" +
+	"`@pickabot whoami` - shows your own teams, overrides, flair and GitHub login\n" +
 	"`@pickabot add @user to <team>` - adds user to team\n" +
 	"`@pickabot remove @user from <team>` - removes user from team\n" +
+	"`@pickabot move @user from <team> to <team>` - moves user between teams\n" +
+	"  (these take several users and teams, e.g. `add @a @b to <team>, <team>`)\n" +
 	"`@pickabot add flair :emoji:` - set flair that appears when you're picked\n" +
 	"`@pickabot remove flair` - remove your flair\n" +
-	"`@pickabot refresh` - refreshes the user/team cache\n"
+	"`@pickabot undo` - reverts your last team or flair change\n" +
+	"`@pickabot alias <team> as <name>` - lets <name> be used for team\n" +
+	"`@pickabot unalias <name>` - removes an alias\n" +
+	"`@pickabot define <name> = <team> + <team>` - makes a team out of other teams\n" +
+	"`@pickabot undefine <name>` - removes a team made of other teams\n" +
+	"`@pickabot configure <team> reviewers=2 strategy=least-recent` - changes how I pick for a team, or shows its settings\n" +
+	"  (also `exclude-requester=true|false`, `channel=#channel|none`, `assign=both|assignee|reviewer` and `github-team=<slug>|none`)\n" +
+	"`@pickabot refresh` - refreshes the user/team cache\n" +
+	"`@pickabot status` - shows how I'm doing: cache freshness, connections, uptime and version\n"
 
 // Override denotes a team override where as user should (not) be included on a team
 type Override struct {
-	User    whoswho.User
-	Team    string
-	Include bool // true = added, false = removed
+	User    whoswho.User `json:"user"`
+	Team    string       `json:"team"`
+	Include bool         `json:"include"` // true = added, false = removed
+	Until   time.Time    `json:"until"`   // zero if the override doesn't expire
 }
 
-var teamOverridesLock = &sync.Mutex{}
-var userFlairLock = &sync.Mutex{}
-
 // DecodeMessage takes a message from the Slack loop and responds appropriately
 func (bot *Bot) DecodeMessage(ev *slackevents.MessageEvent) {
 	if ev == nil {
@@ -111,7 +199,7 @@ func (bot *Bot) DecodeMessage(ev *slackevents.MessageEvent) {
 		if len(refreshMatch) > 0 {
 			bot.Logger.Info("refresh cache match")
 
-			teams, overrides, userFlair, err := buildTeams(bot.WhoIsWhoClient)
+			notices, err := bot.refreshTeams()
 			if err != nil {
 				bot.Logger.CriticalD("user cache refresh failed", logger.M{"error": err})
 				err = bot.SlackEventsService.PostMessage(ev.Channel, "user cache refresh failed")
@@ -119,11 +207,7 @@ func (bot *Bot) DecodeMessage(ev *slackevents.MessageEvent) {
 					bot.Logger.ErrorD("refresh-message-error", logger.M{"error": err.Error()})
 				}
 			} else {
-				bot.TeamToTeamMembers = teams
-				bot.TeamOverrides = overrides
-				bot.UserFlair = userFlair
-				bot.LastCacheRefresh = time.Now()
-				err = bot.SlackEventsService.PostMessage(ev.Channel, "refreshed user cache")
+				err = bot.SlackEventsService.PostMessage(ev.Channel, strings.Join(append([]string{"refreshed user cache"}, notices...), "\n"))
 				if err != nil {
 					bot.Logger.ErrorD("refresh-message-error", logger.M{"error": err.Error()})
 				}
@@ -131,22 +215,94 @@ func (bot *Bot) DecodeMessage(ev *slackevents.MessageEvent) {
 			return
 		}
 
+		// Show the bot's health
+		if statusRegex.MatchString(message) {
+			bot.postStatus(ev)
+			return
+		}
+
+		// List all teams
+		if listTeamsRegex.MatchString(message) {
+			bot.listTeams(ev)
+			return
+		}
+
+		// Describe a user's teams
+		userTeamsMatch := userTeamsRegex.FindStringSubmatch(message)
+		if len(userTeamsMatch) > 1 {
+			bot.describeUserTeams(ev, userTeamsMatch[1])
+			return
+		}
+		if whoamiRegex.MatchString(strings.ToLower(message)) {
+			bot.describeUserTeams(ev, ev.User)
+			return
+		}
+
+		// Undo last change
+		undoMatch := undoRegex.FindStringSubmatch(message)
+		if len(undoMatch) > 0 {
+			bot.undo(ev)
+			return
+		}
+
+		// Aliases
+		addAliasMatch := addAliasRegex.FindStringSubmatch(message)
+		if len(addAliasMatch) > 3 {
+			bot.addAlias(ev, addAliasMatch[2], addAliasMatch[3])
+			return
+		}
+		removeAliasMatch := removeAliasRegex.FindStringSubmatch(message)
+		if len(removeAliasMatch) > 1 {
+			bot.removeAlias(ev, removeAliasMatch[1])
+			return
+		}
+
+		// Composite teams
+		defineCompositeMatch := defineCompositeRegex.FindStringSubmatch(message)
+		if len(defineCompositeMatch) > 2 {
+			bot.defineComposite(ev, defineCompositeMatch[1], defineCompositeMatch[2])
+			return
+		}
+		removeCompositeMatch := removeCompositeRegex.FindStringSubmatch(message)
+		if len(removeCompositeMatch) > 1 {
+			bot.removeComposite(ev, removeCompositeMatch[1])
+			return
+		}
+
+		// Team settings
+		configureMatch := configureTeamRegex.FindStringSubmatch(message)
+		if len(configureMatch) > 3 {
+			bot.configureTeam(ev, configureMatch[2], configureMatch[3])
+			return
+		}
+
 		// Override team
 		overrideMatch := overrideTeamRegex.FindStringSubmatch(message)
-		if len(overrideMatch) > 4 {
-			userID := overrideMatch[1]
+		if len(overrideMatch) > 3 {
+			userIDs := parseUserList(overrideMatch[1])
 			addOrRemove := overrideMatch[2] != "not"
-			teamName := overrideMatch[4]
-			bot.setTeamOverride(ev, userID, teamName, addOrRemove)
+			changes := membershipChanges(parseTeamList(overrideMatch[3]), addOrRemove)
+			bot.setTeamOverride(ev, userIDs, changes)
 			return
 		}
 		// Override team (alternate matcher)
 		overrideMatch2 := overrideTeamRegex2.FindStringSubmatch(message)
-		if len(overrideMatch2) > 5 {
-			userID := overrideMatch2[2]
+		if len(overrideMatch2) > 4 {
+			userIDs := parseUserList(overrideMatch2[2])
 			addOrRemove := overrideMatch2[1] == "add"
-			teamName := overrideMatch2[5]
-			bot.setTeamOverride(ev, userID, teamName, addOrRemove)
+			changes := membershipChanges(parseTeamList(overrideMatch2[4]), addOrRemove)
+			bot.setTeamOverride(ev, userIDs, changes)
+			return
+		}
+		// Move between teams
+		moveMatch := moveTeamRegex.FindStringSubmatch(message)
+		if len(moveMatch) > 3 {
+			userIDs := parseUserList(moveMatch[1])
+			changes := append(
+				membershipChanges(parseTeamList(moveMatch[2]), false),
+				membershipChanges(parseTeamList(moveMatch[3]), true)...,
+			)
+			bot.setTeamOverride(ev, userIDs, changes)
 			return
 		}
 
@@ -174,6 +330,12 @@ func (bot *Bot) DecodeMessage(ev *slackevents.MessageEvent) {
 			return
 		}
 
+		// Assign PRs as their repos' .pickabot.yml say, when no team is given
+		if assignFromRepoConfigRegex.MatchString(message) {
+			bot.assignFromRepoConfig(ev)
+			return
+		}
+
 		// Determine if doing PR assignment
 		setAssigneeMatch := setAssigneeRegex.FindStringSubmatch(message)
 		setAssignee := len(setAssigneeMatch) > 0
@@ -199,21 +361,62 @@ func (bot *Bot) DecodeMessage(ev *slackevents.MessageEvent) {
 	}
 }
 
-// Returns all teams among teams that appear in who-is-who and all overrides
+// parseUserList returns the distinct Slack IDs mentioned in s
+func parseUserList(s string) []string {
+	userIDs := []string{}
+	for _, match := range individualRegex.FindAllStringSubmatch(s, -1) {
+		if !containsString(userIDs, match[1]) {
+			userIDs = append(userIDs, match[1])
+		}
+	}
+	return userIDs
+}
+
+// parseTeamList returns the distinct team names in a list such as "eng-infra, data and #eng-platform"
+func parseTeamList(s string) []string {
+	teams := []string{}
+	for _, item := range teamListSeparatorRegex.Split(strings.TrimSpace(s), -1) {
+		match := teamRegex.FindStringSubmatch(item)
+		if len(match) > 2 && !containsString(teams, match[2]) {
+			teams = append(teams, match[2])
+		}
+	}
+	return teams
+}
+
+func membershipChanges(teams []string, include bool) []membershipChange {
+	changes := []membershipChange{}
+	for _, team := range teams {
+		changes = append(changes, membershipChange{Team: team, Include: include})
+	}
+	return changes
+}
+
+// Returns all teams among teams that appear in who-is-who, all overrides, all alias targets, and all composite teams
 func (bot *Bot) knownTeams() []string {
+	cache := bot.cache()
 	teamsSet := map[string]struct{}{}
-	for team := range bot.TeamToTeamMembers {
+	for team := range cache.TeamToTeamMembers {
+		teamsSet[team] = struct{}{}
+	}
+	for _, team := range bot.compositeTeams() {
 		teamsSet[team] = struct{}{}
 	}
 
 	// TeamToTeamMembers only contains "official" teams as known by who-is-who
 	// But overrides can use any team name
-	teamOverridesLock.Lock()
-	defer teamOverridesLock.Unlock()
-	for _, override := range bot.TeamOverrides {
+	for _, override := range cache.TeamOverrides {
 		teamsSet[override.Team] = struct{}{}
 	}
 
+	// Overrides may still use a team's old name, which is now an alias
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	for alias, team := range bot.State.Aliases {
+		delete(teamsSet, alias)
+		teamsSet[team] = struct{}{}
+	}
+
 	teams := make([]string, 0, len(teamsSet))
 	for team := range teamsSet {
 		teams = append(teams, team)
@@ -221,128 +424,305 @@ func (bot *Bot) knownTeams() []string {
 	return teams
 }
 
+// teamLookupError is returned when a name doesn't identify exactly one team.
+// Candidates are the equally good matches when there are several, and
+// Suggestions are near misses that might have been meant instead.
+type teamLookupError struct {
+	Name        string
+	Candidates  []string
+	Suggestions []string
+}
+
+func (e *teamLookupError) Error() string {
+	if len(e.Candidates) > 0 {
+		return fmt.Sprintf("multiple possible matches: %s", strings.Join(e.Candidates, ", "))
+	}
+	return "no team with that name was found"
+}
+
 // findMatchingTeam allops smarter lookup of team name
 // ex. "eng-team-name", "team-name", "team-namm" (slight misspelling)
 func (bot *Bot) findMatchingTeam(s string) (string, error) {
 	s = strings.TrimPrefix(s, "eng-")
 
+	if team, ok := bot.teamForAlias(s); ok {
+		return team, nil
+	}
+
 	teams := bot.knownTeams()
+	sort.Strings(teams)
 
 	possibles := []string{}
+	suggestions := []string{}
 	for _, t := range teams {
 		if s == t {
 			return t, nil
 		}
-		if lev.DistanceForStrings([]rune(s), []rune(t), lev.DefaultOptions) < 2 {
+		// a near miss of one of the team's aliases counts as a near miss of the team
+		distance := lev.DistanceForStrings([]rune(s), []rune(t), lev.DefaultOptions)
+		for _, alias := range bot.aliasesForTeam(t) {
+			if d := lev.DistanceForStrings([]rune(s), []rune(alias), lev.DefaultOptions); d < distance {
+				distance = d
+			}
+		}
+		if distance < 2 {
 			possibles = append(possibles, t)
+		} else if distance < 3 {
+			suggestions = append(suggestions, t)
 		}
 	}
 
 	if len(possibles) == 1 {
 		return possibles[0], nil
 	} else if len(possibles) > 1 {
-		return "", fmt.Errorf("multiple possible matches: %s", strings.Join(possibles, ", "))
+		return "", &teamLookupError{Name: s, Candidates: possibles, Suggestions: suggestions}
 	}
-	return "", fmt.Errorf("no team with that name was found")
-
+	return "", &teamLookupError{Name: s, Suggestions: suggestions}
 }
 
-func (bot *Bot) setTeamOverrideInWhoIsWho(slackID, team string, include bool, until time.Time) {
-	// If user is in WIW update it,
-	user, err := bot.WhoIsWhoClient.UserBySlackID(slackID)
-	if err != nil {
-		bot.Logger.ErrorD("set-team-override-wiw-user-by-slack", logger.M{"user": slackID, "error": err.Error()})
+// setTeamOverridesInDirectory replaces the user's directory overrides for teams with overrides, in a single update.
+// Teams without an entry in overrides have their override removed.
+func (bot *Bot) setTeamOverridesInDirectory(slackID string, teams []string, overrides []whoswho.PickabotTeamOverride) {
+	if !bot.SyncDirectory {
 		return
 	}
 
-	o := whoswho.PickabotTeamOverride{
-		Team:    team,
-		Include: include,
-		Until:   until.Unix(),
+	// If user is in the directory update it,
+	user, err := bot.Directory.UserBySlackID(slackID)
+	if err != nil {
+		bot.Logger.ErrorD("set-team-override-wiw-user-by-slack", logger.M{"user": slackID, "error": err.Error()})
+		return
 	}
 
-	// Remove any existing override for current team
-	foundIdx := -1
-	for idx, override := range user.Pickabot.TeamOverrides {
-		if override.Team == team {
-			foundIdx = idx
+	// Remove any existing override for the changed teams
+	kept := []whoswho.PickabotTeamOverride{}
+	for _, override := range user.Pickabot.TeamOverrides {
+		if !containsString(teams, override.Team) {
+			kept = append(kept, override)
 		}
 	}
-	if foundIdx > -1 {
-		// remove current entry
-		user.Pickabot.TeamOverrides = append(user.Pickabot.TeamOverrides[:foundIdx], user.Pickabot.TeamOverrides[foundIdx+1:]...)
-	}
 
-	// Add override
-	user.Pickabot.TeamOverrides = append(user.Pickabot.TeamOverrides, o)
+	// Add overrides
+	user.Pickabot.TeamOverrides = append(kept, overrides...)
 
-	_, err = bot.WhoIsWhoClient.UpsertUser("pickabot", user)
+	err = bot.Directory.UpdateUser(user)
 	if err != nil {
 		bot.Logger.ErrorD("set-team-override-wiw-upsert-user", logger.M{"user": slackID, "error": err.Error()})
 		return
 	}
 }
 
-func (bot *Bot) setTeamOverride(ev *slackevents.MessageEvent, userID, teamName string, addOrRemove bool) {
-	bot.Logger.InfoD("set-team-override", logger.M{"user": userID, "team": teamName, "add-or-remove": addOrRemove})
+// membershipChange is a requested addition to, or removal from, a team
+type membershipChange struct {
+	Team    string
+	Include bool // true = add, false = remove
+}
 
-	actualTeamName, err := bot.findMatchingTeam(teamName)
+// setTeamOverride applies every change to every user, then replies with a single summary
+func (bot *Bot) setTeamOverride(ev *slackevents.MessageEvent, userIDs []string, changes []membershipChange) {
+	bot.Logger.InfoD("set-team-override", logger.M{"users": userIDs, "changes": changes})
+
+	resolved := []membershipChange{}
+	for idx, change := range changes {
+		actualTeamName, err := bot.findMatchingTeam(change.Team)
+		if err != nil {
+			bot.reportTeamLookupError(ev, err, func(team string) {
+				retried := append([]membershipChange{}, changes...)
+				retried[idx].Team = team
+				bot.setTeamOverride(ev, userIDs, retried)
+			})
+			return
+		}
+		resolved = append(resolved, membershipChange{Team: actualTeamName, Include: change.Include})
+	}
+
+	deniedTeams := []string{}
+	for _, change := range resolved {
+		for _, userID := range userIDs {
+			if !bot.canModifyTeam(ev.User, userID, change.Team) {
+				bot.Logger.WarnD("set-team-override-denied", logger.M{"requester": ev.User, "user": userID, "team": change.Team, "add-or-remove": change.Include})
+				if !containsString(deniedTeams, change.Team) {
+					deniedTeams = append(deniedTeams, change.Team)
+				}
+			}
+		}
+	}
+	if len(deniedTeams) > 0 {
+		err := bot.SlackEventsService.PostMessage(ev.Channel, fmt.Sprintf(notAllowedToModifyTeam, strings.Join(deniedTeams, ", ")))
+		if err != nil {
+			bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
+		}
+		return
+	}
+
+	var undo undoEntry
+	teamsByUser := map[string][]string{}
+	wiwOverridesByUser := map[string][]whoswho.PickabotTeamOverride{}
+	err := bot.updateCache(func(c *teamCache) error {
+		overrides := c.TeamOverrides
+		for _, userID := range userIDs {
+			for _, change := range resolved {
+				// Remove user override for the team, if already present
+				undoChange := overrideChange{SlackID: userID, Team: change.Team}
+				for idx, o := range overrides {
+					if o.User.SlackID == userID && o.Team == change.Team {
+						previous := o
+						undoChange.Previous = &previous
+						overrides = append(overrides[:idx], overrides[idx+1:]...)
+						break
+					}
+				}
+				undo.Overrides = append(undo.Overrides, undoChange)
+
+				overrides = append(overrides, Override{
+					User:    whoswho.User{SlackID: userID},
+					Team:    change.Team,
+					Include: change.Include,
+				})
+				teamsByUser[userID] = append(teamsByUser[userID], change.Team)
+				wiwOverridesByUser[userID] = append(wiwOverridesByUser[userID], whoswho.PickabotTeamOverride{
+					Team:    change.Team,
+					Include: change.Include,
+					Until:   time.Time{}.Unix(),
+				})
+			}
+		}
+		c.TeamOverrides = overrides
+		return bot.saveOverrides(overrides)
+	})
 	if err != nil {
-		bot.Logger.ErrorD("find-matching-team-error", logger.M{"error": err.Error(), "event-text": ev.Text})
-		err = bot.SlackEventsService.PostMessage(ev.Channel, couldNotFindTeam)
+		bot.Logger.ErrorD("set-team-override-save-error", logger.M{"error": err.Error(), "event-text": ev.Text})
+		err = bot.SlackEventsService.PostMessage(ev.Channel, fmt.Sprintf(couldNotSave, err))
 		if err != nil {
 			bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
 		}
 		return
 	}
+	for _, userID := range userIDs {
+		bot.setTeamOverridesInDirectory(userID, teamsByUser[userID], wiwOverridesByUser[userID])
+	}
+	bot.recordUndo(ev.User, undo)
+
+	err = bot.SlackEventsService.PostMessage(ev.Channel, bot.teamOverrideSummary(userIDs, resolved))
+	if err != nil {
+		bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
+	}
+}
 
-	teamOverridesLock.Lock()
-	defer teamOverridesLock.Unlock()
+// teamOverrideSummary describes a set of membership changes, e.g.
+// "Added <@U1>, <@U2> to team infra! Remember to update https://github.com/orgs/Clever/teams/eng-infra/edit/review_assignment too!"
+func (bot *Bot) teamOverrideSummary(userIDs []string, changes []membershipChange) string {
+	users := []string{}
+	for _, userID := range userIDs {
+		users = append(users, fmt.Sprintf("<@%s>", userID))
+	}
 
-	// Remove user override for the current team, if already present
-	foundIdx := -1
-	for idx, o := range bot.TeamOverrides {
-		if o.User.SlackID == userID && o.Team == actualTeamName {
-			foundIdx = idx
-			break
+	added, removed, links := []string{}, []string{}, []string{}
+	for _, change := range changes {
+		if change.Include {
+			added = append(added, change.Team)
+		} else {
+			removed = append(removed, change.Team)
 		}
+		if link, ok := bot.githubTeamLink(change.Team); ok && !containsString(links, link) {
+			links = append(links, link)
+		}
+	}
+
+	var summary string
+	switch {
+	case len(added) > 0 && len(removed) > 0:
+		summary = fmt.Sprintf("Moved %s from %s to %s!", strings.Join(users, ", "), teamsPhrase(removed), teamsPhrase(added))
+	case len(added) > 0:
+		summary = fmt.Sprintf("Added %s to %s!", strings.Join(users, ", "), teamsPhrase(added))
+	default:
+		summary = fmt.Sprintf("Removed %s from %s!", strings.Join(users, ", "), teamsPhrase(removed))
 	}
-	if foundIdx > -1 {
-		// remove current entry
-		bot.TeamOverrides = append(bot.TeamOverrides[:foundIdx], bot.TeamOverrides[foundIdx+1:]...)
+	if len(links) == 0 {
+		return summary
 	}
+	return fmt.Sprintf("%s Remember to update %s too!", summary, strings.Join(links, ", "))
+}
 
-	bot.TeamOverrides = append(bot.TeamOverrides, Override{
-		User:    whoswho.User{SlackID: userID},
-		Team:    actualTeamName,
-		Include: addOrRemove,
-	})
+// githubTeamLink links to the review assignment settings of team's GitHub team, in the first of GithubOrgNames.
+// The GitHub team is the team's github-team setting, or else eng-<team>. There's no link for namespaced teams,
+// e.g. design/ux, without a github-team setting.
+func (bot *Bot) githubTeamLink(team string) (string, bool) {
+	if len(bot.GithubOrgNames) == 0 {
+		return "", false
+	}
+	slug := bot.teamSettings(team).GithubTeam
+	if slug == "" {
+		if strings.Contains(team, "/") {
+			return "", false
+		}
+		slug = "eng-" + team
+	}
+	return fmt.Sprintf("https://%s/orgs/%s/teams/%s/edit/review_assignment", bot.githubHost(), bot.GithubOrgNames[0], slug), true
+}
 
-	bot.setTeamOverrideInWhoIsWho(userID, actualTeamName, addOrRemove, time.Time{})
+// teamsPhrase formats team names as "team a" or "teams a, b"
+func teamsPhrase(teams []string) string {
+	if len(teams) == 1 {
+		return "team " + teams[0]
+	}
+	return "teams " + strings.Join(teams, ", ")
+}
 
-	if addOrRemove {
-		err = bot.SlackEventsService.PostMessage(ev.Channel, fmt.Sprintf("Added <@%s> to team %s! Remember to update https://github.com/orgs/Clever/teams/eng-%s/edit/review_assignment too!", userID, actualTeamName, actualTeamName))
-		if err != nil {
-			bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
 		}
-	} else {
-		err = bot.SlackEventsService.PostMessage(ev.Channel, fmt.Sprintf("Removed <@%s> from team %s! Remember to update https://github.com/orgs/Clever/teams/eng-%s/edit/review_assignment too!", userID, actualTeamName, actualTeamName))
-		if err != nil {
-			bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
+	}
+	return false
+}
+
+// canModifyTeam reports whether requesterID may change userID's membership of team.
+// Members of the team, the user themselves and admins are allowed.
+func (bot *Bot) canModifyTeam(requesterID, userID, team string) bool {
+	if requesterID == userID {
+		return true
+	}
+	for _, admin := range bot.AdminSlackIDs {
+		if admin == requesterID {
+			return true
 		}
 	}
+	return bot.onTeamRoster(team, requesterID)
 }
 
-func (bot *Bot) updateFlairInWhoIsWho(slackID, flair string) {
-	// If user is in WIW update it,
-	user, err := bot.WhoIsWhoClient.UserBySlackID(slackID)
+// onTeamRoster reports whether slackID is on team in who-is-who, under the team's name or an alias, or on one of
+// the teams a composite team is made of. Overrides don't count, so adding yourself to a team doesn't let you change it.
+func (bot *Bot) onTeamRoster(team, slackID string) bool {
+	cache := bot.cache()
+	for _, name := range append([]string{team}, bot.aliasesForTeam(team)...) {
+		if containsSlackID(cache.TeamToTeamMembers[name], slackID) {
+			return true
+		}
+	}
+	for _, member := range bot.compositeMembers(team) {
+		if bot.onTeamRoster(member, slackID) {
+			return true
+		}
+	}
+	return false
+}
+
+func (bot *Bot) updateFlairInDirectory(slackID, flair string) {
+	if !bot.SyncDirectory {
+		return
+	}
+
+	// If user is in the directory update it,
+	user, err := bot.Directory.UserBySlackID(slackID)
 	if err != nil {
 		bot.Logger.ErrorD("add-flair-wiw-user-by-slack", logger.M{"user": slackID, "flair": flair, "error": err.Error()})
 		return
 	}
 
 	user.Pickabot.Flair = flair
-	_, err = bot.WhoIsWhoClient.UpsertUser("pickabot", user)
+	err = bot.Directory.UpdateUser(user)
 	if err != nil {
 		bot.Logger.ErrorD("add-flair-wiw-upsert-user", logger.M{"user": slackID, "flair": flair, "error": err.Error()})
 		return
@@ -352,31 +732,39 @@ func (bot *Bot) updateFlairInWhoIsWho(slackID, flair string) {
 func (bot *Bot) addFlair(ev *slackevents.MessageEvent, flair string) {
 	bot.Logger.InfoD("add-flair", logger.M{"user": ev.User, "flair": flair})
 
-	userFlairLock.Lock()
-	defer userFlairLock.Unlock()
-
-	err := bot.SlackEventsService.PostMessage(ev.Channel, fmt.Sprintf("<@%s>, I like your style!", ev.User))
-	if err != nil {
-		bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
-	}
-
-	bot.UserFlair[ev.User] = flair
-	bot.updateFlairInWhoIsWho(ev.User, flair)
+	bot.changeFlair(ev, flair, fmt.Sprintf("<@%s>, I like your style!", ev.User))
 }
 
 func (bot *Bot) removeFlair(ev *slackevents.MessageEvent) {
 	bot.Logger.InfoD("remove-flair", logger.M{"user": ev.User})
 
-	userFlairLock.Lock()
-	defer userFlairLock.Unlock()
+	bot.changeFlair(ev, "", "OK, so you don't like flair.")
+}
 
-	err := bot.SlackEventsService.PostMessage(ev.Channel, "OK, so you don't like flair.")
+// changeFlair saves the requester's new flair, which is removed if it's empty, then replies with text
+func (bot *Bot) changeFlair(ev *slackevents.MessageEvent, flair string, text string) {
+	var previous string
+	err := bot.updateCache(func(c *teamCache) error {
+		previous = c.UserFlair[ev.User]
+		if flair == "" {
+			delete(c.UserFlair, ev.User)
+		} else {
+			c.UserFlair[ev.User] = flair
+		}
+		return bot.saveFlair(c.UserFlair)
+	})
 	if err != nil {
-		bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
+		bot.Logger.ErrorD("flair-save-error", logger.M{"error": err.Error(), "event-text": ev.Text})
+		text = fmt.Sprintf(couldNotSave, err)
+	} else {
+		bot.recordUndo(ev.User, undoEntry{Flair: &flairChange{SlackID: ev.User, Previous: previous}})
+		bot.updateFlairInDirectory(ev.User, flair)
 	}
 
-	delete(bot.UserFlair, ev.User)
-	bot.updateFlairInWhoIsWho(ev.User, "")
+	err = bot.SlackEventsService.PostMessage(ev.Channel, text)
+	if err != nil {
+		bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
+	}
 }
 
 func (bot *Bot) pickTeamMember(ev *slackevents.MessageEvent, teamName string, setAssignee bool) {
@@ -385,17 +773,34 @@ func (bot *Bot) pickTeamMember(ev *slackevents.MessageEvent, teamName string, se
 
 	actualTeamName, err := bot.findMatchingTeam(teamName)
 	if err != nil {
-		bot.Logger.ErrorD("find-matching-team-error", logger.M{"error": err.Error(), "event-text": ev.Text})
-		err = bot.SlackEventsService.PostMessage(ev.Channel, couldNotFindTeam)
-		if err != nil {
-			bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
-		}
+		bot.reportTeamLookupError(ev, err, func(team string) {
+			bot.pickTeamMember(ev, team, setAssignee)
+		})
 		return
 	}
 
 	teamMembers := bot.buildTeam(actualTeamName)
+	settings := bot.teamSettings(actualTeamName)
+	omit := &currentUser
+	if settings.IncludeRequester {
+		omit = nil
+	}
+
+	// check the PRs before picking, so a pick that can't be assigned doesn't count towards the pick history
+	var prs []githubPR
+	var notes []string
+	if setAssignee {
+		prs, notes, err = bot.checkPullRequests(ev)
+		if err != nil {
+			err = bot.SlackEventsService.PostMessage(ev.Channel, fmt.Sprintf("I didn't pick anyone: %s", err.Error()))
+			if err != nil {
+				bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
+			}
+			return
+		}
+	}
 
-	user, err := pickUser(teamMembers, &currentUser, bot.RandomSource)
+	users, err := pickUsers(teamMembers, omit, settings.reviewers(), settings.strategy(), bot.lastPicked(), bot.RandomSource)
 	if err != nil {
 		bot.Logger.ErrorD("pick-user-error", logger.M{"error": err.Error(), "event-text": ev.Text})
 		err = bot.SlackEventsService.PostMessage(ev.Channel, pickUserProblem)
@@ -405,30 +810,51 @@ func (bot *Bot) pickTeamMember(ev *slackevents.MessageEvent, teamName string, se
 		return
 	}
 
-	// Add flair
-	flair := bot.UserFlair[user.SlackID]
-	if flair != "" {
-		flair = " " + flair
-	}
-
-	text := fmt.Sprintf("I choose you: <@%s>%s", user.SlackID, flair)
+	mentions := bot.userMentions(users)
+	text := fmt.Sprintf("I choose you: %s", mentions)
+	picked := true
 	if setAssignee {
-		err = bot.setAssignee(ev, user)
+		err := bot.setAssignee(ev, prs, users, settings)
 		if err != nil {
-			text = fmt.Sprintf("Error setting <@%s>%s as pull-request reviewer: %s", user.SlackID, flair, err.Error())
+			text = fmt.Sprintf("Error setting %s as pull-request reviewer: %s", mentions, err.Error())
+			picked = false
 		} else {
-			text = fmt.Sprintf("Set <@%s>%s as pull-request reviewer", user.SlackID, flair)
+			text = strings.Join(append([]string{fmt.Sprintf("Set %s as pull-request reviewer", mentions)}, notes...), "\n")
+			bot.notifyTeamChannel(ev, actualTeamName, settings, mentions)
 		}
 	}
+	if picked {
+		bot.recordPicks(users)
+	}
 	err = bot.SlackEventsService.PostMessage(ev.Channel, text)
 	if err != nil {
 		bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
 	}
 }
 
+// notifyTeamChannel tells a team's own channel, if it has one, that its members were assigned pull requests
+func (bot *Bot) notifyTeamChannel(ev *slackevents.MessageEvent, team string, settings teamSettings, mentions string) {
+	if settings.Channel == "" || settings.Channel == ev.Channel {
+		return
+	}
+	prs, _ := parseMessageForPRs(bot.githubHost(), bot.GithubOrgNames, ev.Text)
+	if len(prs) == 0 {
+		return
+	}
+	urls := []string{}
+	for _, pr := range prs {
+		urls = append(urls, pullRequestURL(bot.githubHost(), pr))
+	}
+	text := fmt.Sprintf("<@%s> asked team %s for a review: %s will look at %s", ev.User, team, mentions, strings.Join(urls, " "))
+	err := bot.SlackEventsService.PostMessage(settings.Channel, text)
+	if err != nil {
+		bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
+	}
+}
+
 func (bot *Bot) pickIndividual(ev *slackevents.MessageEvent, individualSlackID string, setAssignee bool) {
 	bot.Logger.InfoD("pick-individual", logger.M{"slack ID": individualSlackID})
-	user, err := bot.WhoIsWhoClient.UserBySlackID(individualSlackID)
+	user, err := bot.Directory.UserBySlackID(individualSlackID)
 	if err != nil {
 		bot.Logger.ErrorD("pick-user-error", logger.M{"error": err.Error(), "event-text": ev.Text})
 		err = bot.SlackEventsService.PostMessage(ev.Channel, pickUserProblem)
@@ -439,18 +865,21 @@ func (bot *Bot) pickIndividual(ev *slackevents.MessageEvent, individualSlackID s
 	}
 
 	// Add flair
-	flair := bot.UserFlair[user.SlackID]
+	flair := bot.cache().UserFlair[user.SlackID]
 	if flair != "" {
 		flair = " " + flair
 	}
 
 	text := fmt.Sprintf("I choose you: <@%s>%s", user.SlackID, flair)
 	if setAssignee {
-		err = bot.setAssignee(ev, user)
+		prs, notes, err := bot.checkPullRequests(ev)
+		if err == nil {
+			err = bot.setAssignee(ev, prs, []whoswho.User{user}, teamSettings{})
+		}
 		if err != nil {
 			text = fmt.Sprintf("Error setting <@%s>%s as pull-request reviewer: %s", user.SlackID, flair, err.Error())
 		} else {
-			text = fmt.Sprintf("Set <@%s>%s as pull-request reviewer", user.SlackID, flair)
+			text = strings.Join(append([]string{fmt.Sprintf("Set <@%s>%s as pull-request reviewer", user.SlackID, flair)}, notes...), "\n")
 		}
 	}
 	err = bot.SlackEventsService.PostMessage(ev.Channel, text)
@@ -459,52 +888,104 @@ func (bot *Bot) pickIndividual(ev *slackevents.MessageEvent, individualSlackID s
 	}
 }
 
-func (bot *Bot) setAssignee(ev *slackevents.MessageEvent, user whoswho.User) error {
-	var err error
+// githubUser makes sure user has a GitHub login, looking them up in who-is-who if needed
+func (bot *Bot) githubUser(ev *slackevents.MessageEvent, user whoswho.User) (whoswho.User, error) {
+	if user.Github != "" {
+		return user, nil
+	}
+	// try to fetch the user from SlackID
+	user, err := bot.Directory.UserBySlackID(user.SlackID)
+	// error if there is still no valid account associated
 	if user.Github == "" {
-		// try to fetch the user from SlackID
-		user, err = bot.WhoIsWhoClient.UserBySlackID(user.SlackID)
-		// error if there is still no valid account associated
-		if user.Github == "" {
-			bot.Logger.ErrorD("set-assignee-error", logger.M{
-				"error":                fmt.Sprintf("no valid Github account for %s", user.Email),
-				"event-text":           ev.Text,
-				"user-pickabot-config": user.Pickabot,
-				"user-slack":           user.Slack,
-				"user-slack-id":        user.SlackID,
-			})
-			return fmt.Errorf("no github account for slack user <@%s>", user.SlackID)
+		bot.Logger.ErrorD("set-assignee-error", logger.M{
+			"error":                fmt.Sprintf("no valid Github account for %s", user.Email),
+			"event-text":           ev.Text,
+			"user-pickabot-config": user.Pickabot,
+			"user-slack":           user.Slack,
+			"user-slack-id":        user.SlackID,
+		})
+		return user, fmt.Errorf("no github account for slack user <@%s>", user.SlackID)
+	}
+	// bubble up who-is-who-error
+	if err != nil {
+		bot.Logger.ErrorD("set-assignee-wiw-error", logger.M{
+			"error":      err.Error(),
+			"event-text": ev.Text,
+		})
+		return user, fmt.Errorf("error fetching <@%s> from who-is-who. Please manually assign instead", user.SlackID)
+	}
+	return user, nil
+}
+
+// checkPullRequests looks up the PRs in the message before anyone is picked for them. It returns the PRs to
+// assign and notes for the user about skipped PRs, drafts and duplicates, or an error if every PR was skipped.
+// Closed and merged PRs are skipped.
+func (bot *Bot) checkPullRequests(ev *slackevents.MessageEvent) ([]githubPR, []string, error) {
+	prs, _ := parseMessageForPRs(bot.githubHost(), bot.GithubOrgNames, ev.Text)
+	// the dev bot shouldn't hit the API
+	if bot.DevMode {
+		return prs, nil, nil
+	}
+
+	checked := []githubPR{}
+	notes := []string{}
+	skipped := []string{}
+	for _, pr := range prs {
+		skip, warning := bot.checkPullRequest(pr)
+		if skip != "" {
+			skipped = append(skipped, fmt.Sprintf("I skipped %s: %s", pullRequestURL(bot.githubHost(), pr), skip))
+			continue
 		}
-		// bubble up who-is-who-error
+		if warning != "" {
+			notes = append(notes, warning)
+		}
+		checked = append(checked, pr)
+	}
+	if len(skipped) > 0 && len(checked) == 0 {
+		return nil, nil, fmt.Errorf("%s", strings.Join(skipped, "; "))
+	}
+	if note := bot.duplicatePRsNote(ev.Text); note != "" {
+		notes = append(notes, note)
+	}
+	return checked, append(skipped, notes...), nil
+}
+
+// setAssignee assigns users to, and/or requests their reviews on, prs, as settings.Assign says
+func (bot *Bot) setAssignee(ev *slackevents.MessageEvent, prs []githubPR, users []whoswho.User, settings teamSettings) error {
+	logins := []string{}
+	for _, u := range users {
+		user, err := bot.githubUser(ev, u)
 		if err != nil {
-			bot.Logger.ErrorD("set-assignee-wiw-error", logger.M{
-				"error":      err.Error(),
-				"event-text": ev.Text,
-			})
-			return fmt.Errorf("error fetching <@%s> from who-is-who. Please manually assign instead", user.SlackID)
+			return err
 		}
+		logins = append(logins, user.Github)
 	}
+	assign := settings.assign()
+
 	var reposWithAssigneeSet []string
 	var reposWithReviewerSet []string
-	prs := parseMessageForPRs(bot.GithubOrgName, ev.Text)
 	for _, pr := range prs {
 		var err error
 		// the dev bot shouldn't hit the API
 		if bot.DevMode {
-			err = bot.SlackEventsService.PostMessage(ev.Channel, fmt.Sprintf("would have assigned %s to %s", user.Github, pr.Repo))
+			err = bot.SlackEventsService.PostMessage(ev.Channel, fmt.Sprintf("would have assigned %s to %s", strings.Join(logins, ", "), pr.Repo))
 			if err != nil {
 				bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
 			}
-		} else {
-			_, _, err = bot.GithubClient.AddAssignees(context.Background(), pr.Owner, pr.Repo, pr.PRNumber, []string{user.Github})
+			continue
+		}
+		if assign != assignReviewer {
+			_, _, err = bot.GithubClient.AddAssignees(context.Background(), pr.Owner, pr.Repo, pr.PRNumber, logins)
 			if err != nil {
-				bot.Logger.ErrorD("set-assignee-failure-warning", logger.M{"warning": err.Error(), "event-text": ev.Text, "repo": pr.Repo, "user": user.Github})
+				bot.Logger.ErrorD("set-assignee-failure-warning", logger.M{"warning": err.Error(), "event-text": ev.Text, "repo": pr.Repo, "user": logins})
 			} else {
 				reposWithAssigneeSet = append(reposWithAssigneeSet, pr.Repo)
 			}
-			_, _, err = bot.GithubClient.AddReviewers(context.Background(), pr.Owner, pr.Repo, pr.PRNumber, []string{user.Github})
+		}
+		if assign != assignAssignee {
+			_, _, err = bot.GithubClient.AddReviewers(context.Background(), pr.Owner, pr.Repo, pr.PRNumber, logins)
 			if err != nil {
-				bot.Logger.ErrorD("set-reviewer-failure-warning", logger.M{"warning": err.Error(), "event-text": ev.Text, "repo": pr.Repo, "user": user.Github})
+				bot.Logger.ErrorD("set-reviewer-failure-warning", logger.M{"warning": err.Error(), "event-text": ev.Text, "repo": pr.Repo, "user": logins})
 			} else {
 				reposWithReviewerSet = append(reposWithReviewerSet, pr.Repo)
 			}
@@ -516,25 +997,60 @@ func (bot *Bot) setAssignee(ev *slackevents.MessageEvent, user whoswho.User) err
 			"assigned-repos":  reposWithAssigneeSet,
 			"reviewing-repos": reposWithReviewerSet,
 			"event-text":      ev.Text,
-			"user":            user.Github,
+			"user":            logins,
 		})
 	}
-
 	return nil
 }
 
+// checkPullRequest looks up pr before it's assigned. It returns why pr should be skipped, or a warning about
+// it for the user, or neither.
+func (bot *Bot) checkPullRequest(pr githubPR) (string, string) {
+	status, err := bot.GithubClient.GetPullRequestStatus(context.Background(), pr.Owner, pr.Repo, pr.PRNumber)
+	return bot.pullRequestProblem(pr, status, err)
+}
+
+// pullRequestProblem is checkPullRequest for a pull request that's already been looked up, with err the error
+// looking it up
+func (bot *Bot) pullRequestProblem(pr githubPR, status github.PullRequestStatus, err error) (string, string) {
+	switch {
+	case err == github.ErrNoAccess && pr.Issue:
+		return "GitHub has no pull request with that number, so it's probably an issue, and I can only assign pull requests", ""
+	case err == github.ErrNoAccess:
+		return fmt.Sprintf("GitHub says it doesn't exist. If it does, pickabot's GitHub app installation on %s "+
+			"may not have access to %s: an org owner can add the repo under the installation's repository access", pr.Owner, pr.Repo), ""
+	case err != nil:
+		bot.Logger.ErrorD("get-pull-request-error", logger.M{"error": err.Error(), "repo": pr.Repo, "pr": pr.PRNumber})
+		return fmt.Sprintf("I couldn't look it up: %s", err), ""
+	case status.Merged:
+		return "it's already merged", ""
+	case status.State == "closed":
+		return "it's closed", ""
+	case status.Draft:
+		return "", fmt.Sprintf("%s is still a draft, so it may not be ready for review yet", pullRequestURL(bot.githubHost(), pr))
+	}
+	return "", ""
+}
+
 func (bot *Bot) buildTeam(teamName string) []whoswho.User {
-	teamOverridesLock.Lock()
-	defer teamOverridesLock.Unlock()
+	// overrides made before a team was renamed use its old name
+	teamNames := append([]string{teamName}, bot.aliasesForTeam(teamName)...)
 
-	teamMembers := bot.TeamToTeamMembers[teamName]
+	// A composite team is everyone on the teams it's made of
+	var compositeMembers []whoswho.User
+	for _, member := range bot.compositeMembers(teamName) {
+		compositeMembers = append(compositeMembers, bot.buildTeam(member)...)
+	}
+
+	cache := bot.cache()
+	teamMembers := append(append([]whoswho.User{}, cache.TeamToTeamMembers[teamName]...), compositeMembers...)
 	finalTeam := []whoswho.User{}
 
 	// Remove some members
 	for _, user := range teamMembers {
 		includeUser := true
-		for _, override := range bot.TeamOverrides {
-			if user.SlackID == override.User.SlackID && teamName == override.Team && !override.Include {
+		for _, override := range cache.TeamOverrides {
+			if user.SlackID == override.User.SlackID && containsString(teamNames, override.Team) && !override.Include {
 				// user has been removed
 				includeUser = false
 				break
@@ -546,8 +1062,8 @@ func (bot *Bot) buildTeam(teamName string) []whoswho.User {
 	}
 
 	// Add some members
-	for _, override := range bot.TeamOverrides {
-		if teamName == override.Team && override.Include {
+	for _, override := range cache.TeamOverrides {
+		if containsString(teamNames, override.Team) && override.Include {
 			finalTeam = append(finalTeam, override.User)
 		}
 	}
@@ -574,25 +1090,120 @@ func (bot *Bot) listTeamMembers(ev *slackevents.MessageEvent, teamName string) {
 	bot.Logger.DebugD("list-team-members", logger.M{"team": teamName, "current-user": ev.User})
 	actualTeamName, err := bot.findMatchingTeam(teamName)
 	if err != nil {
-		bot.Logger.ErrorD("find-matching-team-error", logger.M{"error": err.Error(), "event-text": ev.Text})
-		err = bot.SlackEventsService.PostMessage(ev.Channel, couldNotFindTeam)
-		if err != nil {
-			bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
-		}
+		bot.reportTeamLookupError(ev, err, func(team string) {
+			bot.listTeamMembers(ev, team)
+		})
 		return
 	}
 
 	teamMembers := bot.buildTeam(actualTeamName)
+	usernames, err := bot.memberNames(teamMembers)
+	if err != nil {
+		bot.Logger.ErrorD("slack-api-error", logger.M{"error": err.Error(), "event-text": ev.Text})
+		return
+	}
+
+	text := fmt.Sprintf("Team %s has the following members: %s", actualTeamName, strings.Join(usernames, ", "))
+
+	// Members of a composite team are grouped by the team they come from
+	if members := bot.compositeMembers(actualTeamName); members != nil {
+		inComposite := map[string]struct{}{}
+		for _, u := range teamMembers {
+			inComposite[u.SlackID] = struct{}{}
+		}
+
+		lines := []string{fmt.Sprintf("Team %s has the following members:", actualTeamName)}
+		for _, member := range members {
+			fromMember := []whoswho.User{}
+			for _, u := range bot.buildTeam(member) {
+				if _, ok := inComposite[u.SlackID]; ok {
+					fromMember = append(fromMember, u)
+					delete(inComposite, u.SlackID)
+				}
+			}
+			names, err := bot.memberNames(fromMember)
+			if err != nil {
+				bot.Logger.ErrorD("slack-api-error", logger.M{"error": err.Error(), "event-text": ev.Text})
+				return
+			}
+			lines = append(lines, fmt.Sprintf("%s: %s", member, strings.Join(names, ", ")))
+		}
+
+		// anyone left was added to the composite team directly
+		added := []whoswho.User{}
+		for _, u := range teamMembers {
+			if _, ok := inComposite[u.SlackID]; ok {
+				added = append(added, u)
+			}
+		}
+		if len(added) > 0 {
+			names, err := bot.memberNames(added)
+			if err != nil {
+				bot.Logger.ErrorD("slack-api-error", logger.M{"error": err.Error(), "event-text": ev.Text})
+				return
+			}
+			lines = append(lines, fmt.Sprintf("added to %s: %s", actualTeamName, strings.Join(names, ", ")))
+		}
+		text = strings.Join(lines, "\n")
+	}
+
+	err = bot.SlackEventsService.PostMessage(ev.Channel, text)
+	if err != nil {
+		bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
+	}
+}
+
+// listTeams replies with every known team and its size, flagging teams that
+// only exist because of overrides, and teams with no members
+func (bot *Bot) listTeams(ev *slackevents.MessageEvent) {
+	bot.Logger.DebugD("list-teams", logger.M{"current-user": ev.User})
+
+	teams := bot.knownTeams()
+	sort.Strings(teams)
+
+	lines := []string{"I know about these teams:"}
+	for _, team := range teams {
+		count := len(bot.buildTeam(team))
+		line := fmt.Sprintf("• %s: %d members", team, count)
+		if count == 1 {
+			line = fmt.Sprintf("• %s: 1 member", team)
+		}
+
+		notes := []string{}
+		if members := bot.compositeMembers(team); members != nil {
+			notes = append(notes, "made of "+strings.Join(members, " + "))
+		} else if _, official := bot.cache().TeamToTeamMembers[team]; !official {
+			notes = append(notes, "only from overrides")
+		}
+		if count == 0 {
+			notes = append(notes, "empty")
+		}
+		if aliases := bot.aliasesForTeam(team); len(aliases) > 0 {
+			notes = append(notes, "also called "+strings.Join(aliases, ", "))
+		}
+		if len(notes) > 0 {
+			line += fmt.Sprintf(" _(%s)_", strings.Join(notes, "; "))
+		}
+		lines = append(lines, line)
+	}
+
+	err := bot.SlackEventsService.PostMessage(ev.Channel, strings.Join(lines, "\n"))
+	if err != nil {
+		bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
+	}
+}
+
+// memberNames returns the sorted Slack names of users, with their flair
+func (bot *Bot) memberNames(users []whoswho.User) ([]string, error) {
 	usernames := []string{}
-	for _, t := range teamMembers {
+	for _, t := range users {
 		info, err := bot.SlackAPIService.GetUserInfo(t.SlackID)
 		if err != nil {
-			bot.Logger.ErrorD("slack-api-error", logger.M{"error": err.Error(), "event-text": ev.Text, "failed-user": t.SlackID})
-			return
+			return nil, fmt.Errorf("error getting Slack user %s: %s", t.SlackID, err)
 		}
 
 		// Add flair
-		flair := bot.UserFlair[t.SlackID]
+		flair := bot.cache().UserFlair[t.SlackID]
 		if flair != "" {
 			flair = " " + flair
 		}
@@ -600,9 +1211,5 @@ func (bot *Bot) listTeamMembers(ev *slackevents.MessageEvent, teamName string) {
 		usernames = append(usernames, info.Name+flair)
 	}
 	sort.Strings(usernames)
-
-	err = bot.SlackEventsService.PostMessage(ev.Channel, fmt.Sprintf("Team %s has the following members: %s", actualTeamName, strings.Join(usernames, ", ")))
-	if err != nil {
-		bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
-	}
+	return usernames, nil
 }
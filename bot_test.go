@@ -3,8 +3,10 @@ package main
 import (
 	"math/rand"
 	"testing"
+	"time"
 
 	"github.com/Clever/kayvee-go/logger"
+	pickabotgithub "github.com/Clever/pickabot/github"
 	whoswho "github.com/Clever/who-is-who/go-client"
 	"github.com/golang/mock/gomock"
 	"github.com/slack-go/slack"
@@ -18,6 +20,9 @@ const testGithubOrg = "Clever"
 
 var testGithubUser = whoswho.User{SlackID: "G1", Github: "github"}
 
+// testOpenPR is the status of an open pull request that's ready for review
+var testOpenPR = pickabotgithub.PullRequestStatus{State: "open"}
+
 func makeSlackMessage(text string) *slackevents.MessageEvent {
 	return &slackevents.MessageEvent{
 		User:    testUserID,
@@ -49,9 +54,18 @@ func getMockBot(t *testing.T) (*Bot, *BotMocks, *gomock.Controller) {
 	mockbot := &Bot{
 		SlackAPIService:    mockSlackAPIService,
 		SlackEventsService: mockSlackEventsService,
-		UserFlair:          map[string]string{},
-		TeamOverrides:      []Override{},
-		TeamToTeamMembers: map[string][]whoswho.User{
+		StateStore:         &memoryStateStore{},
+		Logger:             logger.New(testChannel),
+		Name:               testUserID,
+		RandomSource:       rand.NewSource(0),
+		Directory:          &whoIsWhoDirectory{Client: mockWhoIsWhoClient, Logger: logger.New(testChannel)},
+		SyncDirectory:      true,
+		GithubClient:       mockGithubClient,
+		GithubOrgNames:     []string{testGithubOrg},
+	}
+
+	setCache(mockbot, func(c *teamCache) {
+		c.TeamToTeamMembers = map[string][]whoswho.User{
 			"example-team": []whoswho.User{
 				whoswho.User{SlackID: "U1"},
 				whoswho.User{SlackID: "U2"},
@@ -63,14 +77,8 @@ func getMockBot(t *testing.T) (*Bot, *BotMocks, *gomock.Controller) {
 				whoswho.User{SlackID: testUserID},
 			},
 			"github-user-team": []whoswho.User{testGithubUser, whoswho.User{SlackID: "G2", Github: "G2Github"}},
-		},
-		Logger:         logger.New(testChannel),
-		Name:           testUserID,
-		RandomSource:   rand.NewSource(0),
-		WhoIsWhoClient: mockWhoIsWhoClient,
-		GithubClient:   mockGithubClient,
-		GithubOrgName:  testGithubOrg,
-	}
+		}
+	})
 
 	return mockbot, &BotMocks{
 		SlackAPI:       mockSlackAPIService,
@@ -80,6 +88,14 @@ func getMockBot(t *testing.T) (*Bot, *BotMocks, *gomock.Controller) {
 	}, mockCtrl
 }
 
+// setCache changes the bot's cached teams, overrides and flair
+func setCache(bot *Bot, update func(*teamCache)) {
+	bot.updateCache(func(c *teamCache) error {
+		update(c)
+		return nil
+	})
+}
+
 func TestMessageNotForAnyone(t *testing.T) {
 	mockbot, _, mockCtrl := getMockBot(t)
 	defer mockCtrl.Finish()
@@ -138,13 +154,15 @@ func TestPickOverrideTeam(t *testing.T) {
 	input := "<@U1234> pick an override-only-team"
 	t.Log("Input = ", input)
 	mockbot, mocks, mockCtrl := getMockBot(t)
-	mockbot.TeamOverrides = []Override{{
-		User: whoswho.User{
-			SlackID: "U5",
-		},
-		Team:    "override-only-team",
-		Include: true,
-	}}
+	setCache(mockbot, func(c *teamCache) {
+		c.TeamOverrides = []Override{{
+			User: whoswho.User{
+				SlackID: "U5",
+			},
+			Team:    "override-only-team",
+			Include: true,
+		}}
+	})
 	defer mockCtrl.Finish()
 
 	msg := "I choose you: <@U5>"
@@ -198,10 +216,11 @@ func TestAssignTeamMember(t *testing.T) {
 		expectedMessage string
 	}{
 		{
-			name:         "no calls if the user doesn't have a github account",
+			name:         "doesn't assign if the user doesn't have a github account",
 			inputMessage: "<@U1234> assign a example-team for https://github.com/Clever/fake-repo/pull/1",
 			expectedUser: "U3",
 			expectations: func(mocks *BotMocks) {
+				mocks.GithubClient.EXPECT().GetPullRequestStatus(gomock.Any(), testGithubOrg, "fake-repo", 1).Return(testOpenPR, nil)
 				mocks.WhoIsWhoClient.EXPECT().UserBySlackID(gomock.Any()).Return(whoswho.User{SlackID: "U3"}, nil)
 			},
 			expectedMessage: "Error setting <@U3> as pull-request reviewer: no github account for slack user <@U3>",
@@ -212,8 +231,10 @@ func TestAssignTeamMember(t *testing.T) {
 			expectedUser: testGithubUser.SlackID,
 			expectations: func(mocks *BotMocks) {
 				// check calls
+				mocks.GithubClient.EXPECT().GetPullRequestStatus(gomock.Any(), testGithubOrg, "fake-repo", 1).Return(testOpenPR, nil)
 				mocks.GithubClient.EXPECT().AddAssignees(gomock.Any(), testGithubOrg, "fake-repo", 1, gomock.Any())
 				mocks.GithubClient.EXPECT().AddReviewers(gomock.Any(), testGithubOrg, "fake-repo", 1, gomock.Any())
+				mocks.GithubClient.EXPECT().GetPullRequestStatus(gomock.Any(), testGithubOrg, "fake-repo2", 1).Return(testOpenPR, nil)
 				mocks.GithubClient.EXPECT().AddAssignees(gomock.Any(), testGithubOrg, "fake-repo2", 1, gomock.Any())
 				mocks.GithubClient.EXPECT().AddReviewers(gomock.Any(), testGithubOrg, "fake-repo2", 1, gomock.Any())
 			},
@@ -231,6 +252,39 @@ func TestAssignTeamMember(t *testing.T) {
 	}
 }
 
+func TestAssignTeamMemberRecordsPicks(t *testing.T) {
+	t.Log("Nobody is picked for closed pull requests")
+	mockbot, mocks, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+	mocks.GithubClient.EXPECT().GetPullRequestStatus(gomock.Any(), testGithubOrg, "fake-repo", 1).
+		Return(pickabotgithub.PullRequestStatus{State: "closed"}, nil)
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, "I didn't pick anyone: I skipped https://github.com/Clever/fake-repo/pull/1: it's closed")
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> assign a github-user-team for https://github.com/Clever/fake-repo/pull/1"))
+	assert.Empty(t, mockbot.State.PickHistory)
+
+	t.Log("Picks for open pull requests are recorded")
+	gomock.InOrder(
+		mocks.GithubClient.EXPECT().GetPullRequestStatus(gomock.Any(), testGithubOrg, "fake-repo", 1).Return(testOpenPR, nil),
+		mocks.GithubClient.EXPECT().AddAssignees(gomock.Any(), testGithubOrg, "fake-repo", 1, gomock.Any()),
+		mocks.GithubClient.EXPECT().AddReviewers(gomock.Any(), testGithubOrg, "fake-repo", 1, gomock.Any()),
+	)
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, "Set <@G1> as pull-request reviewer")
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> assign a github-user-team for https://github.com/Clever/fake-repo/pull/1"))
+	assert.Contains(t, mockbot.State.PickHistory, "G1")
+
+	t.Log("Picks are saved together, a little later")
+	saved, err := mockbot.StateStore.Load()
+	assert.NoError(t, err)
+	assert.NotContains(t, saved.PickHistory, "G1")
+	assert.NotNil(t, mockbot.pickHistorySave)
+	mockbot.pickHistorySave.Stop()
+	mockbot.savePickHistory()
+	saved, err = mockbot.StateStore.Load()
+	assert.NoError(t, err)
+	assert.Contains(t, saved.PickHistory, "G1")
+	assert.Nil(t, mockbot.pickHistorySave)
+}
+
 func TestPickTeamMemberInvalidTeam(t *testing.T) {
 	mockbot, mocks, mockCtrl := getMockBot(t)
 	defer mockCtrl.Finish()
@@ -290,10 +344,11 @@ func TestAssignIndividual(t *testing.T) {
 		expectedMessage string
 	}{
 		{
-			name:         "no github calls if the user doesn't have a github account",
+			name:         "doesn't assign if the user doesn't have a github account",
 			inputMessage: "<@U1234> assign <@U5> for https://github.com/Clever/fake-repo/pull/1",
 			expectedUser: "U5",
 			expectations: func(mocks *BotMocks) {
+				mocks.GithubClient.EXPECT().GetPullRequestStatus(gomock.Any(), testGithubOrg, "fake-repo", 1).Return(testOpenPR, nil)
 				mocks.WhoIsWhoClient.EXPECT().UserBySlackID("U5").Return(whoswho.User{SlackID: "U5"}, nil)
 				mocks.WhoIsWhoClient.EXPECT().UserBySlackID("U5").Return(whoswho.User{SlackID: "U5"}, nil)
 			},
@@ -307,6 +362,8 @@ func TestAssignIndividual(t *testing.T) {
 				gomock.InOrder(
 					mocks.WhoIsWhoClient.EXPECT().UserBySlackID("G1").Return(testGithubUser, nil),
 					// check github calls
+					mocks.GithubClient.EXPECT().GetPullRequestStatus(gomock.Any(), testGithubOrg, "fake-repo", 1).Return(testOpenPR, nil),
+					mocks.GithubClient.EXPECT().GetPullRequestStatus(gomock.Any(), testGithubOrg, "fake-repo2", 1).Return(testOpenPR, nil),
 					mocks.GithubClient.EXPECT().AddAssignees(gomock.Any(), testGithubOrg, "fake-repo", 1, gomock.Any()),
 					mocks.GithubClient.EXPECT().AddReviewers(gomock.Any(), testGithubOrg, "fake-repo", 1, gomock.Any()),
 					mocks.GithubClient.EXPECT().AddAssignees(gomock.Any(), testGithubOrg, "fake-repo2", 1, gomock.Any()),
@@ -315,6 +372,20 @@ func TestAssignIndividual(t *testing.T) {
 			},
 			expectedMessage: "Set <@G1> as pull-request reviewer",
 		},
+		{
+			name:         "assigns a pull request mentioned twice once",
+			inputMessage: "<@U1234> assign <@G1> for https://github.com/Clever/fake-repo/pull/1 fake-repo#1",
+			expectedUser: testGithubUser.SlackID,
+			expectations: func(mocks *BotMocks) {
+				gomock.InOrder(
+					mocks.WhoIsWhoClient.EXPECT().UserBySlackID("G1").Return(testGithubUser, nil),
+					mocks.GithubClient.EXPECT().GetPullRequestStatus(gomock.Any(), testGithubOrg, "fake-repo", 1).Return(testOpenPR, nil),
+					mocks.GithubClient.EXPECT().AddAssignees(gomock.Any(), testGithubOrg, "fake-repo", 1, gomock.Any()),
+					mocks.GithubClient.EXPECT().AddReviewers(gomock.Any(), testGithubOrg, "fake-repo", 1, gomock.Any()),
+				)
+			},
+			expectedMessage: "Set <@G1> as pull-request reviewer\nhttps://github.com/Clever/fake-repo/pull/1 was mentioned more than once, so I only assigned it once",
+		},
 	} {
 		t.Logf("Case: %s. Input: %s", test.name, test.inputMessage)
 		mockbot, mocks, mockCtrl := getMockBot(t)
@@ -327,6 +398,83 @@ func TestAssignIndividual(t *testing.T) {
 	}
 }
 
+func TestAssignChecksPullRequestState(t *testing.T) {
+	for _, test := range []struct {
+		name            string
+		inputMessage    string
+		expectations    func(*BotMocks)
+		expectedMessage string
+	}{
+		{
+			name:         "skips closed pull requests",
+			inputMessage: "<@U1234> assign <@G1> for https://github.com/Clever/fake-repo/pull/1",
+			expectations: func(mocks *BotMocks) {
+				mocks.GithubClient.EXPECT().GetPullRequestStatus(gomock.Any(), testGithubOrg, "fake-repo", 1).
+					Return(pickabotgithub.PullRequestStatus{State: "closed"}, nil)
+			},
+			expectedMessage: "Error setting <@G1> as pull-request reviewer: I skipped https://github.com/Clever/fake-repo/pull/1: it's closed",
+		},
+		{
+			name:         "skips merged pull requests, and assigns the rest",
+			inputMessage: "<@U1234> assign <@G1> for fake-repo#1 fake-repo2#2",
+			expectations: func(mocks *BotMocks) {
+				gomock.InOrder(
+					mocks.GithubClient.EXPECT().GetPullRequestStatus(gomock.Any(), testGithubOrg, "fake-repo", 1).
+						Return(pickabotgithub.PullRequestStatus{State: "closed", Merged: true}, nil),
+					mocks.GithubClient.EXPECT().GetPullRequestStatus(gomock.Any(), testGithubOrg, "fake-repo2", 2).Return(testOpenPR, nil),
+					mocks.GithubClient.EXPECT().AddAssignees(gomock.Any(), testGithubOrg, "fake-repo2", 2, gomock.Any()),
+					mocks.GithubClient.EXPECT().AddReviewers(gomock.Any(), testGithubOrg, "fake-repo2", 2, gomock.Any()),
+				)
+			},
+			expectedMessage: "Set <@G1> as pull-request reviewer\nI skipped https://github.com/Clever/fake-repo/pull/1: it's already merged",
+		},
+		{
+			name:         "warns about drafts",
+			inputMessage: "<@U1234> assign <@G1> for https://github.com/Clever/fake-repo/pull/1",
+			expectations: func(mocks *BotMocks) {
+				gomock.InOrder(
+					mocks.GithubClient.EXPECT().GetPullRequestStatus(gomock.Any(), testGithubOrg, "fake-repo", 1).
+						Return(pickabotgithub.PullRequestStatus{State: "open", Draft: true}, nil),
+					mocks.GithubClient.EXPECT().AddAssignees(gomock.Any(), testGithubOrg, "fake-repo", 1, gomock.Any()),
+					mocks.GithubClient.EXPECT().AddReviewers(gomock.Any(), testGithubOrg, "fake-repo", 1, gomock.Any()),
+				)
+			},
+			expectedMessage: "Set <@G1> as pull-request reviewer\nhttps://github.com/Clever/fake-repo/pull/1 is still a draft, so it may not be ready for review yet",
+		},
+		{
+			name:         "explains pull requests the app can't see",
+			inputMessage: "<@U1234> assign <@G1> for https://github.com/Clever/secret-repo/pull/1",
+			expectations: func(mocks *BotMocks) {
+				mocks.GithubClient.EXPECT().GetPullRequestStatus(gomock.Any(), testGithubOrg, "secret-repo", 1).
+					Return(pickabotgithub.PullRequestStatus{}, pickabotgithub.ErrNoAccess)
+			},
+			expectedMessage: "Error setting <@G1> as pull-request reviewer: I skipped https://github.com/Clever/secret-repo/pull/1: " +
+				"GitHub says it doesn't exist. If it does, pickabot's GitHub app installation on Clever may not have access to secret-repo: " +
+				"an org owner can add the repo under the installation's repository access",
+		},
+		{
+			name:         "explains issues",
+			inputMessage: "<@U1234> assign <@G1> for https://github.com/Clever/fake-repo/issues/3",
+			expectations: func(mocks *BotMocks) {
+				mocks.GithubClient.EXPECT().GetPullRequestStatus(gomock.Any(), testGithubOrg, "fake-repo", 3).
+					Return(pickabotgithub.PullRequestStatus{}, pickabotgithub.ErrNoAccess)
+			},
+			expectedMessage: "Error setting <@G1> as pull-request reviewer: I skipped https://github.com/Clever/fake-repo/issues/3: " +
+				"GitHub has no pull request with that number, so it's probably an issue, and I can only assign pull requests",
+		},
+	} {
+		t.Logf("Case: %s. Input: %s", test.name, test.inputMessage)
+		mockbot, mocks, mockCtrl := getMockBot(t)
+		defer mockCtrl.Finish()
+
+		mocks.WhoIsWhoClient.EXPECT().UserBySlackID("G1").Return(testGithubUser, nil)
+		test.expectations(mocks)
+		mocks.SlackEvents.EXPECT().PostMessage(testChannel, test.expectedMessage)
+
+		mockbot.DecodeMessage(makeSlackMessage(test.inputMessage))
+	}
+}
+
 func TestPickIndividualInvalidName(t *testing.T) {
 	mockbot, mocks, mockCtrl := getMockBot(t)
 	defer mockCtrl.Finish()
@@ -360,6 +508,7 @@ func TestPickUserNoUserErrorDueToOmit(t *testing.T) {
 func TestAddOverride(t *testing.T) {
 	mockbot, mocks, mockCtrl := getMockBot(t)
 	defer mockCtrl.Finish()
+	mockbot.AdminSlackIDs = []string{testUserID}
 
 	t.Log("Can set override to add a user to a team")
 	msg := "Added <@U5555> to team example-team! Remember to update https://github.com/orgs/Clever/teams/eng-example-team/edit/review_assignment too!"
@@ -367,7 +516,7 @@ func TestAddOverride(t *testing.T) {
 	mocks.WhoIsWhoClient.EXPECT().UserBySlackID("U5555")
 	mocks.WhoIsWhoClient.EXPECT().UpsertUser("pickabot", gomock.Any())
 
-	assert.Equal(t, 0, len(mockbot.TeamOverrides))
+	assert.Equal(t, 0, len(mockbot.cache().TeamOverrides))
 	mockbot.DecodeMessage(makeSlackMessage("<@U1234> <@U5555> is an eng-example-team"))
 	assert.Equal(t, []Override{
 		Override{
@@ -375,7 +524,7 @@ func TestAddOverride(t *testing.T) {
 			Team:    "example-team",
 			Include: true,
 		},
-	}, mockbot.TeamOverrides)
+	}, mockbot.cache().TeamOverrides)
 
 	t.Log("Can set override to remove a user from a team")
 	msg2 := "Removed <@U7777> from team example-team! Remember to update https://github.com/orgs/Clever/teams/eng-example-team/edit/review_assignment too!"
@@ -395,7 +544,7 @@ func TestAddOverride(t *testing.T) {
 			Team:    "example-team",
 			Include: false,
 		},
-	}, mockbot.TeamOverrides)
+	}, mockbot.cache().TeamOverrides)
 
 	t.Log("Can update a user's override")
 	msg3 := "Added <@U7777> to team example-team! Remember to update https://github.com/orgs/Clever/teams/eng-example-team/edit/review_assignment too!"
@@ -415,13 +564,14 @@ func TestAddOverride(t *testing.T) {
 			Team:    "example-team",
 			Include: true,
 		},
-	}, mockbot.TeamOverrides)
+	}, mockbot.cache().TeamOverrides)
 
 }
 
 func TestAddOverrideAlternateMessageMatcher(t *testing.T) {
 	mockbot, mocks, mockCtrl := getMockBot(t)
 	defer mockCtrl.Finish()
+	mockbot.AdminSlackIDs = []string{testUserID}
 
 	t.Log("Can set override to add a user to a team")
 	msg := "Added <@U5555> to team example-team! Remember to update https://github.com/orgs/Clever/teams/eng-example-team/edit/review_assignment too!"
@@ -429,7 +579,7 @@ func TestAddOverrideAlternateMessageMatcher(t *testing.T) {
 	mocks.WhoIsWhoClient.EXPECT().UserBySlackID("U5555")
 	mocks.WhoIsWhoClient.EXPECT().UpsertUser("pickabot", gomock.Any())
 
-	assert.Equal(t, 0, len(mockbot.TeamOverrides))
+	assert.Equal(t, 0, len(mockbot.cache().TeamOverrides))
 	mockbot.DecodeMessage(makeSlackMessage("<@U1234> add <@U5555> to eng-example-team"))
 	assert.Equal(t, []Override{
 		Override{
@@ -437,7 +587,7 @@ func TestAddOverrideAlternateMessageMatcher(t *testing.T) {
 			Team:    "example-team",
 			Include: true,
 		},
-	}, mockbot.TeamOverrides)
+	}, mockbot.cache().TeamOverrides)
 
 	t.Log("Can set override to remove a user from a team")
 	msg2 := "Removed <@U7777> from team example-team! Remember to update https://github.com/orgs/Clever/teams/eng-example-team/edit/review_assignment too!"
@@ -457,7 +607,7 @@ func TestAddOverrideAlternateMessageMatcher(t *testing.T) {
 			Team:    "example-team",
 			Include: false,
 		},
-	}, mockbot.TeamOverrides)
+	}, mockbot.cache().TeamOverrides)
 
 	t.Log("Can update a user's override")
 	msg3 := "Added <@U7777> to team example-team! Remember to update https://github.com/orgs/Clever/teams/eng-example-team/edit/review_assignment too!"
@@ -477,10 +627,260 @@ func TestAddOverrideAlternateMessageMatcher(t *testing.T) {
 			Team:    "example-team",
 			Include: true,
 		},
-	}, mockbot.TeamOverrides)
+	}, mockbot.cache().TeamOverrides)
 
 }
 
+func TestBulkOverrides(t *testing.T) {
+	for _, test := range []struct {
+		name              string
+		inputMessage      string
+		expectedOverrides []Override
+		expectedMessage   string
+	}{
+		{
+			name:         "adds several users to several teams",
+			inputMessage: "<@U1234> add <@U5> <@U6> to example-team, eng-empty-team",
+			expectedOverrides: []Override{
+				{User: whoswho.User{SlackID: "U5"}, Team: "example-team", Include: true},
+				{User: whoswho.User{SlackID: "U5"}, Team: "empty-team", Include: true},
+				{User: whoswho.User{SlackID: "U6"}, Team: "example-team", Include: true},
+				{User: whoswho.User{SlackID: "U6"}, Team: "empty-team", Include: true},
+			},
+			expectedMessage: "Added <@U5>, <@U6> to teams example-team, empty-team! Remember to update " +
+				"https://github.com/orgs/Clever/teams/eng-example-team/edit/review_assignment, " +
+				"https://github.com/orgs/Clever/teams/eng-empty-team/edit/review_assignment too!",
+		},
+		{
+			name:         "removes several users with the 'is not' form",
+			inputMessage: "<@U1234> <@U5>, <@U6> and <@U5> are not example-team",
+			expectedOverrides: []Override{
+				{User: whoswho.User{SlackID: "U5"}, Team: "example-team", Include: false},
+				{User: whoswho.User{SlackID: "U6"}, Team: "example-team", Include: false},
+			},
+			expectedMessage: "Removed <@U5>, <@U6> from team example-team! Remember to update " +
+				"https://github.com/orgs/Clever/teams/eng-example-team/edit/review_assignment too!",
+		},
+		{
+			name:         "moves users between teams",
+			inputMessage: "<@U1234> move <@U5> <@U6> from example-team to empty-team and same-user-team",
+			expectedOverrides: []Override{
+				{User: whoswho.User{SlackID: "U5"}, Team: "example-team", Include: false},
+				{User: whoswho.User{SlackID: "U5"}, Team: "empty-team", Include: true},
+				{User: whoswho.User{SlackID: "U5"}, Team: "same-user-team", Include: true},
+				{User: whoswho.User{SlackID: "U6"}, Team: "example-team", Include: false},
+				{User: whoswho.User{SlackID: "U6"}, Team: "empty-team", Include: true},
+				{User: whoswho.User{SlackID: "U6"}, Team: "same-user-team", Include: true},
+			},
+			expectedMessage: "Moved <@U5>, <@U6> from team example-team to teams empty-team, same-user-team! Remember to update " +
+				"https://github.com/orgs/Clever/teams/eng-example-team/edit/review_assignment, " +
+				"https://github.com/orgs/Clever/teams/eng-empty-team/edit/review_assignment, " +
+				"https://github.com/orgs/Clever/teams/eng-same-user-team/edit/review_assignment too!",
+		},
+	} {
+		t.Logf("Case: %s. Input: %s", test.name, test.inputMessage)
+		mockbot, mocks, mockCtrl := getMockBot(t)
+		defer mockCtrl.Finish()
+		mockbot.AdminSlackIDs = []string{testUserID}
+
+		// one who-is-who update per user
+		for _, userID := range []string{"U5", "U6"} {
+			mocks.WhoIsWhoClient.EXPECT().UserBySlackID(userID).Return(whoswho.User{SlackID: userID}, nil)
+		}
+		mocks.WhoIsWhoClient.EXPECT().UpsertUser("pickabot", gomock.Any()).Times(2).Do(func(_ string, u whoswho.User) {
+			assert.Equal(t, len(test.expectedOverrides)/2, len(u.Pickabot.TeamOverrides))
+		})
+		mocks.SlackEvents.EXPECT().PostMessage(testChannel, test.expectedMessage)
+
+		mockbot.DecodeMessage(makeSlackMessage(test.inputMessage))
+		assert.Equal(t, test.expectedOverrides, mockbot.cache().TeamOverrides)
+
+		t.Log("Undo reverts the whole command")
+		for _, userID := range []string{"U5", "U6"} {
+			mocks.WhoIsWhoClient.EXPECT().UserBySlackID(userID)
+		}
+		mocks.WhoIsWhoClient.EXPECT().UpsertUser("pickabot", gomock.Any()).Times(2)
+		mocks.SlackEvents.EXPECT().PostMessage(testChannel, gomock.Any())
+
+		mockbot.DecodeMessage(makeSlackMessage("<@U1234> undo"))
+		assert.Equal(t, 0, len(mockbot.cache().TeamOverrides))
+	}
+}
+
+func TestAddOverridePermissions(t *testing.T) {
+	for _, test := range []struct {
+		name          string
+		requester     string
+		admins        []string
+		inputMessage  string
+		expectChange  bool
+		expectMessage string
+	}{
+		{
+			name:          "non-member cannot change a team",
+			requester:     testUserID,
+			inputMessage:  "<@U1234> add <@U5555> to example-team",
+			expectMessage: "Sorry, only members of team example-team, the user being changed, or a pickabot admin can change that team's members",
+		},
+		{
+			name:          "team member can change their team",
+			requester:     "U1",
+			inputMessage:  "<@U1234> add <@U5555> to example-team",
+			expectChange:  true,
+			expectMessage: "Added <@U5555> to team example-team! Remember to update https://github.com/orgs/Clever/teams/eng-example-team/edit/review_assignment too!",
+		},
+		{
+			name:          "user can change their own membership",
+			requester:     "U5555",
+			inputMessage:  "<@U1234> <@U5555> is example-team",
+			expectChange:  true,
+			expectMessage: "Added <@U5555> to team example-team! Remember to update https://github.com/orgs/Clever/teams/eng-example-team/edit/review_assignment too!",
+		},
+		{
+			name:          "admin can change any team",
+			requester:     testUserID,
+			admins:        []string{"U9", testUserID},
+			inputMessage:  "<@U1234> remove <@U5555> from example-team",
+			expectChange:  true,
+			expectMessage: "Removed <@U5555> from team example-team! Remember to update https://github.com/orgs/Clever/teams/eng-example-team/edit/review_assignment too!",
+		},
+	} {
+		t.Logf("Case: %s. Input: %s", test.name, test.inputMessage)
+		mockbot, mocks, mockCtrl := getMockBot(t)
+		defer mockCtrl.Finish()
+		mockbot.AdminSlackIDs = test.admins
+
+		if test.expectChange {
+			mocks.WhoIsWhoClient.EXPECT().UserBySlackID("U5555")
+			mocks.WhoIsWhoClient.EXPECT().UpsertUser("pickabot", gomock.Any())
+		}
+		mocks.SlackEvents.EXPECT().PostMessage(testChannel, test.expectMessage)
+
+		ev := makeSlackMessage(test.inputMessage)
+		ev.User = test.requester
+		mockbot.DecodeMessage(ev)
+		if test.expectChange {
+			assert.Equal(t, 1, len(mockbot.cache().TeamOverrides))
+		} else {
+			assert.Equal(t, 0, len(mockbot.cache().TeamOverrides))
+		}
+	}
+}
+
+func TestAddOverridePermissionsIgnoreOverrides(t *testing.T) {
+	mockbot, mocks, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+
+	// adding yourself is allowed, but doesn't make you a member who can change others
+	gomock.InOrder(
+		mocks.WhoIsWhoClient.EXPECT().UserBySlackID(testUserID),
+		mocks.WhoIsWhoClient.EXPECT().UpsertUser("pickabot", gomock.Any()),
+	)
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, "Added <@U0> to team example-team! Remember to update https://github.com/orgs/Clever/teams/eng-example-team/edit/review_assignment too!")
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, "Sorry, only members of team example-team, the user being changed, or a pickabot admin can change that team's members")
+
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> add <@U0> to example-team"))
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> remove <@U1> from example-team"))
+	assert.Equal(t, []Override{{User: whoswho.User{SlackID: testUserID}, Team: "example-team", Include: true}}, mockbot.cache().TeamOverrides)
+}
+
+func TestTeamOverrideSummaryLinks(t *testing.T) {
+	mockbot, _, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+	mockbot.GithubOrgNames = []string{"Labs", "Clever"}
+	mockbot.GithubWebHost = "github.example.com"
+	mockbot.State.TeamSettings = map[string]teamSettings{"data": {GithubTeam: "data-reviewers"}}
+
+	assert.Equal(t, "Added <@U1> to teams infra, data! Remember to update "+
+		"https://github.example.com/orgs/Labs/teams/eng-infra/edit/review_assignment, "+
+		"https://github.example.com/orgs/Labs/teams/data-reviewers/edit/review_assignment too!",
+		mockbot.teamOverrideSummary([]string{"U1"}, []membershipChange{{Team: "infra", Include: true}, {Team: "data", Include: true}}))
+
+	t.Log("Namespaced teams don't have a GitHub team unless it's configured")
+	assert.Equal(t, "Removed <@U1> from team design/ux!",
+		mockbot.teamOverrideSummary([]string{"U1"}, []membershipChange{{Team: "design/ux"}}))
+}
+
+func TestUndo(t *testing.T) {
+	mockbot, mocks, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+	mockbot.AdminSlackIDs = []string{testUserID}
+
+	t.Log("Nothing to undo without any changes")
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, nothingToUndo)
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> undo"))
+
+	t.Log("Undoing a new override removes it")
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, gomock.Any())
+	mocks.WhoIsWhoClient.EXPECT().UserBySlackID("U5555").Times(2)
+	mocks.WhoIsWhoClient.EXPECT().UpsertUser("pickabot", gomock.Any())
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> add <@U5555> to example-team"))
+	assert.Equal(t, 1, len(mockbot.cache().TeamOverrides))
+
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, "Restored <@U5555>'s previous membership of team example-team")
+	mocks.WhoIsWhoClient.EXPECT().UpsertUser("pickabot", gomock.Any()).Do(func(_ string, u whoswho.User) {
+		assert.Equal(t, 0, len(u.Pickabot.TeamOverrides))
+	})
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> undo"))
+	assert.Equal(t, []Override{}, mockbot.cache().TeamOverrides)
+
+	t.Log("Undoing a changed override restores it, including its expiry")
+	until := time.Unix(1700000000, 0)
+	setCache(mockbot, func(c *teamCache) {
+		c.TeamOverrides = []Override{{User: whoswho.User{SlackID: "U5555"}, Team: "example-team", Include: true, Until: until}}
+	})
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, gomock.Any())
+	mocks.WhoIsWhoClient.EXPECT().UserBySlackID("U5555").Times(2)
+	mocks.WhoIsWhoClient.EXPECT().UpsertUser("pickabot", gomock.Any())
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> remove <@U5555> from example-team"))
+
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, "Restored <@U5555>'s previous membership of team example-team")
+	mocks.WhoIsWhoClient.EXPECT().UpsertUser("pickabot", gomock.Any()).Do(func(_ string, u whoswho.User) {
+		assert.Equal(t, []whoswho.PickabotTeamOverride{{Team: "example-team", Include: true, Until: until.Unix()}}, u.Pickabot.TeamOverrides)
+	})
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> undo"))
+	assert.Equal(t, []Override{{User: whoswho.User{SlackID: "U5555"}, Team: "example-team", Include: true, Until: until}}, mockbot.cache().TeamOverrides)
+
+	t.Log("Undoing a flair change restores the previous flair")
+	setCache(mockbot, func(c *teamCache) {
+		c.UserFlair[testUserID] = ":wave:"
+	})
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, gomock.Any())
+	mocks.WhoIsWhoClient.EXPECT().UserBySlackID(testUserID).Times(2)
+	mocks.WhoIsWhoClient.EXPECT().UpsertUser("pickabot", gomock.Any()).Times(2)
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> add flair :dance:"))
+	assert.Equal(t, ":dance:", mockbot.cache().UserFlair[testUserID])
+
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, "Restored <@U0>'s previous flair")
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> undo"))
+	assert.Equal(t, ":wave:", mockbot.cache().UserFlair[testUserID])
+
+	t.Log("Changes are undone in reverse order until there are none left")
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, nothingToUndo)
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> undo"))
+}
+
+func TestUndoSameOverrideChangedTwice(t *testing.T) {
+	mockbot, mocks, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+
+	// one command that added U5555 to example-team, then removed them
+	original := Override{User: whoswho.User{SlackID: "U5555"}, Team: "example-team", Include: false}
+	added := Override{User: whoswho.User{SlackID: "U5555"}, Team: "example-team", Include: true}
+	mockbot.recordUndo(testUserID, undoEntry{Overrides: []overrideChange{
+		{SlackID: "U5555", Team: "example-team", Previous: &original},
+		{SlackID: "U5555", Team: "example-team", Previous: &added},
+	}})
+
+	mocks.WhoIsWhoClient.EXPECT().UserBySlackID("U5555")
+	mocks.WhoIsWhoClient.EXPECT().UpsertUser("pickabot", gomock.Any()).Do(func(_ string, u whoswho.User) {
+		assert.Equal(t, []whoswho.PickabotTeamOverride{{Team: "example-team", Include: false, Until: original.Until.Unix()}}, u.Pickabot.TeamOverrides)
+	})
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, "Restored <@U5555>'s previous membership of team example-team")
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> undo"))
+	assert.Equal(t, []Override{original}, mockbot.cache().TeamOverrides)
+}
+
 func TestAddFlair(t *testing.T) {
 	mockbot, mocks, mockCtrl := getMockBot(t)
 	defer mockCtrl.Finish()
@@ -490,15 +890,16 @@ func TestAddFlair(t *testing.T) {
 	mocks.WhoIsWhoClient.EXPECT().UserBySlackID("U0")
 	mocks.WhoIsWhoClient.EXPECT().UpsertUser("pickabot", gomock.Any())
 
-	assert.Equal(t, 0, len(mockbot.UserFlair))
-	assert.Equal(t, "", mockbot.UserFlair["U0"])
+	assert.Equal(t, 0, len(mockbot.cache().UserFlair))
+	assert.Equal(t, "", mockbot.cache().UserFlair["U0"])
 	mockbot.DecodeMessage(makeSlackMessage("<@U1234> add flair :dance:"))
-	assert.Equal(t, ":dance:", mockbot.UserFlair["U0"])
+	assert.Equal(t, ":dance:", mockbot.cache().UserFlair["U0"])
 }
 
 func TestSetAssigneeWithEmptyGithubFromOverride(t *testing.T) {
 	mockbot, mocks, mockCtrl := getMockBot(t)
 	defer mockCtrl.Finish()
+	mockbot.AdminSlackIDs = []string{testUserID}
 
 	// first we add a member to the team overrides
 	userMsg := "<@U1234> add <@U7777> to eng-empty-team"
@@ -514,6 +915,8 @@ func TestSetAssigneeWithEmptyGithubFromOverride(t *testing.T) {
 		mocks.WhoIsWhoClient.EXPECT().UserBySlackID("U7777"),
 		mocks.WhoIsWhoClient.EXPECT().UpsertUser("pickabot", gomock.Any()),
 		// mocks for pick from empty-team
+		// the pull request is checked before picking
+		mocks.GithubClient.EXPECT().GetPullRequestStatus(gomock.Any(), testGithubOrg, "fake-repo", 1).Return(testOpenPR, nil),
 		// verify we try to look up the user by slack id from whoswho
 		mocks.WhoIsWhoClient.EXPECT().UserBySlackID("U7777").Return(whoswho.User{Github: "7777", SlackID: "U7777"}, nil),
 		// verify subsequent calls to github
@@ -528,7 +931,331 @@ func TestSetAssigneeWithEmptyGithubFromOverride(t *testing.T) {
 			Team:    "empty-team",
 			Include: true,
 		},
-	}, mockbot.TeamOverrides)
+	}, mockbot.cache().TeamOverrides)
 
 	mockbot.DecodeMessage(makeSlackMessage(userMsg2))
 }
+
+func TestAmbiguousTeamChoice(t *testing.T) {
+	mockbot, mocks, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+	setCache(mockbot, func(c *teamCache) {
+		c.TeamToTeamMembers["team-a"] = []whoswho.User{{SlackID: "U8"}}
+		c.TeamToTeamMembers["team-b"] = []whoswho.User{{SlackID: "U9"}}
+		c.TeamToTeamMembers["team-bc"] = []whoswho.User{}
+	})
+
+	t.Log("Offers a button per candidate team, and near misses")
+	var buttons []*slack.ButtonBlockElement
+	mocks.SlackEvents.EXPECT().PostBlocks(testChannel,
+		"`team-` could be more than one team: team-a, team-b. Which did you mean? Or did you mean team-bc?", gomock.Any()).
+		Do(func(_, _ string, blocks ...slack.Block) {
+			assert.Equal(t, 2, len(blocks))
+			for _, element := range blocks[1].(*slack.ActionBlock).Elements.ElementSet {
+				buttons = append(buttons, element.(*slack.ButtonBlockElement))
+			}
+		})
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> pick a team-"))
+	assert.Equal(t, 3, len(buttons))
+	assert.True(t, IsTeamChoiceAction(&slack.BlockAction{ActionID: buttons[1].ActionID}))
+
+	t.Log("Only the requester can choose")
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, "Sorry <@U5>, only <@U0> can choose the team for that command")
+	mockbot.HandleTeamChoice("U5", testChannel, buttons[1].Value)
+
+	t.Log("Choosing a team runs the original command against it")
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, "I choose you: <@U9>")
+	mockbot.HandleTeamChoice(testUserID, testChannel, buttons[1].Value)
+
+	t.Log("A choice can only be made once")
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, teamChoiceExpired)
+	mockbot.HandleTeamChoice(testUserID, testChannel, buttons[0].Value)
+}
+
+func TestTeamNotFoundSuggestions(t *testing.T) {
+	mockbot, mocks, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+
+	mocks.SlackEvents.EXPECT().PostBlocks(testChannel, couldNotFindTeam+". Did you mean empty-team?", gomock.Any())
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> who is emptteam"))
+}
+
+func TestAliases(t *testing.T) {
+	mockbot, mocks, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+
+	t.Log("Can alias a team")
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, "OK, ex now means team example-team")
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> alias eng-example-team as ex"))
+	saved, _ := mockbot.StateStore.Load()
+	assert.Equal(t, map[string]string{"ex": "example-team"}, saved.Aliases)
+
+	t.Log("Can't alias a name that's already a team")
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, "Sorry, there's already a team called empty-team")
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> alias example-team as empty-team"))
+
+	t.Log("The alias can be used in place of the team")
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, "I choose you: <@U3>")
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> pick an ex"))
+
+	t.Log("Aliases aren't listed as teams of their own")
+	assert.False(t, containsString(mockbot.knownTeams(), "ex"))
+
+	t.Log("Can remove an alias")
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, "OK, ex is no longer an alias")
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> unalias ex"))
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, "Sorry, ex isn't an alias")
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> unalias ex"))
+	saved, _ = mockbot.StateStore.Load()
+	assert.Equal(t, map[string]string{}, saved.Aliases)
+}
+
+func TestRefreshAliasesRenamedTeams(t *testing.T) {
+	mockbot, mocks, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+	mockbot.NotificationChannel = "notices"
+
+	users := []whoswho.User{}
+	for _, id := range []string{"U1", "U2", "U3"} {
+		users = append(users, whoswho.User{SlackID: id, Active: true, Team: "Engineering - Renamed Team"})
+	}
+	overridden := whoswho.User{SlackID: "U5", Active: true, Team: "Engineering - Other"}
+	overridden.Pickabot.TeamOverrides = []whoswho.PickabotTeamOverride{{Team: "example-team", Include: true}}
+	users = append(users, overridden)
+	mocks.WhoIsWhoClient.EXPECT().GetUserList().Return(users, nil)
+	notice := "Team example-team was renamed to renamed-team in who-is-who. example-team still works as an alias"
+	mocks.SlackEvents.EXPECT().PostMessage("notices", notice)
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, "refreshed user cache\n"+notice+"\n"+
+		"Team example-team: <@U5> is now added by an override\n"+
+		"Team github-user-team: <@G1>, <@G2> left\n"+
+		"Team other: <@U5> joined\n"+
+		"Team renamed-team: <@U4> left\n"+
+		"Team same-user-team: <@U0> left")
+
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> refresh"))
+	assert.Equal(t, map[string]string{"example-team": "renamed-team"}, mockbot.State.Aliases)
+
+	t.Log("The old name finds the renamed team, including overrides made under the old name")
+	team, err := mockbot.findMatchingTeam("eng-example-team")
+	assert.NoError(t, err)
+	assert.Equal(t, "renamed-team", team)
+	ids := []string{}
+	for _, u := range mockbot.buildTeam(team) {
+		ids = append(ids, u.SlackID)
+	}
+	assert.Equal(t, []string{"U1", "U2", "U3", "U5"}, ids)
+}
+
+func TestRenamedTeamKeepsSettings(t *testing.T) {
+	mockbot, _, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+	settings := teamSettings{Reviewers: 2, Channel: "C123", GithubTeam: "example-reviewers"}
+	mockbot.State.TeamSettings = map[string]teamSettings{"example-team": settings}
+
+	mockbot.aliasRenamedTeams(map[string]string{"example-team": "renamed-team"})
+	assert.Equal(t, settings, mockbot.teamSettings("renamed-team"))
+	team, ok := mockbot.teamForGithubTeam("example-reviewers")
+	assert.True(t, ok)
+	assert.Equal(t, "renamed-team", team)
+}
+
+func TestRenamedTeamInComposite(t *testing.T) {
+	mockbot, _, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+	mockbot.State.Composites = map[string][]string{"combo": {"example-team", "github-user-team"}}
+
+	// who-is-who renames example-team to renamed-team
+	setCache(mockbot, func(c *teamCache) {
+		c.TeamToTeamMembers["renamed-team"] = c.TeamToTeamMembers["example-team"]
+		delete(c.TeamToTeamMembers, "example-team")
+	})
+	mockbot.aliasRenamedTeams(map[string]string{"example-team": "renamed-team"})
+
+	assert.Equal(t, []string{"renamed-team", "github-user-team"}, mockbot.compositeMembers("combo"))
+	ids := []string{}
+	for _, u := range mockbot.buildTeam("combo") {
+		ids = append(ids, u.SlackID)
+	}
+	assert.Contains(t, ids, "U1")
+	assert.Contains(t, ids, testGithubUser.SlackID)
+}
+
+func TestCompositeTeams(t *testing.T) {
+	mockbot, mocks, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+	setCache(mockbot, func(c *teamCache) {
+		c.TeamOverrides = []Override{
+			{User: whoswho.User{SlackID: "U1"}, Team: "example-team", Include: false},
+			{User: whoswho.User{SlackID: "U9"}, Team: "both", Include: true},
+		}
+	})
+
+	t.Log("Can define a composite team")
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, "OK, team both is now example-team + github-user-team")
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> define both = eng-example-team + github-user-team"))
+	saved, _ := mockbot.StateStore.Load()
+	assert.Equal(t, map[string][]string{"both": {"example-team", "github-user-team"}}, saved.Composites)
+
+	t.Log("It has the members of its teams, including overrides")
+	ids := []string{}
+	for _, u := range mockbot.buildTeam("both") {
+		ids = append(ids, u.SlackID)
+	}
+	assert.Equal(t, []string{"U2", "U3", "U4", "G1", "G2", "U9"}, ids)
+
+	t.Log("Listing it groups members by their team")
+	for _, id := range ids {
+		mocks.SlackAPI.EXPECT().GetUserInfo(id).Return(makeSlackUser("name-"+id), nil).AnyTimes()
+	}
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, "Team both has the following members:\n"+
+		"example-team: name-U2, name-U3, name-U4\n"+
+		"github-user-team: name-G1, name-G2\n"+
+		"added to both: name-U9")
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> who is both"))
+
+	t.Log("Composite teams can't contain composite teams")
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, "Sorry, both is itself a composite team, and composite teams can't contain each other")
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> define more = both + empty-team"))
+
+	t.Log("Can't reuse an existing team's name")
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, "Sorry, there's already a team called empty-team")
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> empty-team = example-team, github-user-team"))
+
+	t.Log("Can remove a composite team")
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, "OK, team both is gone")
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> undefine both"))
+	assert.Equal(t, 0, len(mockbot.State.Composites))
+}
+
+func TestPickNamespacedTeam(t *testing.T) {
+	mockbot, mocks, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+	setCache(mockbot, func(c *teamCache) {
+		c.TeamToTeamMembers["design/ux"] = []whoswho.User{{SlackID: "U8"}}
+	})
+
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, "I choose you: <@U8>")
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> pick a design/ux"))
+}
+
+func TestDescribeUserTeams(t *testing.T) {
+	mockbot, mocks, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+	setCache(mockbot, func(c *teamCache) {
+		c.TeamOverrides = []Override{
+			{User: whoswho.User{SlackID: "G1"}, Team: "example-team", Include: true, Until: time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC)},
+			{User: whoswho.User{SlackID: "G1"}, Team: "empty-team", Include: true},
+			{User: whoswho.User{SlackID: "G1"}, Team: "same-user-team", Include: false},
+			{User: whoswho.User{SlackID: "U5"}, Team: "empty-team", Include: false},
+		}
+	})
+	mockbot.State.Composites = map[string][]string{"both": {"example-team", "empty-team"}}
+	setCache(mockbot, func(c *teamCache) {
+		c.UserFlair["G1"] = ":dance:"
+	})
+
+	t.Log("Describes another user")
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, "<@G1> is on team github-user-team in who-is-who\n"+
+		"Added to teams empty-team, example-team (until 2030-01-02)\n"+
+		"Removed from team same-user-team\n"+
+		"Through composite teams, also on team both\n"+
+		"Flair: :dance:\n"+
+		"GitHub: github")
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> what teams is <@G1> on?"))
+
+	t.Log("Describes the requester, falling back to who-is-who for their GitHub login")
+	mocks.WhoIsWhoClient.EXPECT().UserBySlackID(testUserID).Return(whoswho.User{SlackID: testUserID}, nil)
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, "<@U0> is on team same-user-team in who-is-who\n"+
+		"GitHub: none, so I can't assign them pull requests")
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> whoami"))
+}
+
+func TestListTeams(t *testing.T) {
+	mockbot, mocks, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+	setCache(mockbot, func(c *teamCache) {
+		c.TeamOverrides = []Override{
+			{User: whoswho.User{SlackID: "U5"}, Team: "override-only-team", Include: true},
+			{User: whoswho.User{SlackID: "U1"}, Team: "removed-only-team", Include: false},
+		}
+	})
+	mockbot.State.Aliases = map[string]string{"ex": "example-team"}
+	mockbot.State.Composites = map[string][]string{"both": {"example-team", "same-user-team"}}
+
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, "I know about these teams:\n"+
+		"• both: 5 members _(made of example-team + same-user-team)_\n"+
+		"• empty-team: 0 members _(empty)_\n"+
+		"• example-team: 4 members _(also called ex)_\n"+
+		"• github-user-team: 2 members\n"+
+		"• override-only-team: 1 member _(only from overrides)_\n"+
+		"• removed-only-team: 0 members _(only from overrides; empty)_\n"+
+		"• same-user-team: 1 member")
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> list teams"))
+}
+
+func TestConfigureTeam(t *testing.T) {
+	mockbot, mocks, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+	mockbot.AdminSlackIDs = []string{testUserID}
+
+	gomock.InOrder(
+		mocks.SlackEvents.EXPECT().PostMessage(testChannel, "Team example-team has settings: reviewers=1 strategy=random exclude-requester=true channel=none assign=both github-team=none"),
+		mocks.SlackEvents.EXPECT().PostMessage(testChannel, "OK, team example-team now has settings: reviewers=2 strategy=least-recent exclude-requester=true channel=<#C123> assign=reviewer github-team=none"),
+		mocks.SlackEvents.EXPECT().PostMessage(testChannel, "Sorry, reviewers must be a number from 1 to 10. I know about: reviewers=<n> strategy=random|least-recent exclude-requester=true|false channel=#channel|none assign=both|assignee|reviewer github-team=<slug>|none"),
+	)
+
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> configure example-team"))
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> configure example-team reviewers=2 strategy=least-recent channel=<#C123|example> assign=reviewer"))
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> configure example-team reviewers=many"))
+
+	assert.Equal(t, teamSettings{Reviewers: 2, Strategy: strategyLeastRecent, Channel: "C123", Assign: assignReviewer}, mockbot.teamSettings("example-team"))
+	saved, err := mockbot.StateStore.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, mockbot.teamSettings("example-team"), saved.TeamSettings["example-team"])
+}
+
+func TestConfigureTeamPermissions(t *testing.T) {
+	mockbot, mocks, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, "Sorry, only members of team example-team or a pickabot admin can change its settings")
+
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> configure example-team reviewers=2"))
+	assert.Equal(t, teamSettings{}, mockbot.teamSettings("example-team"))
+}
+
+func TestPickWithTeamSettings(t *testing.T) {
+	t.Log("Picks several reviewers, least recently picked first")
+	mockbot, mocks, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+	mockbot.State.TeamSettings = map[string]teamSettings{
+		"example-team": {Reviewers: 2, Strategy: strategyLeastRecent},
+	}
+	mockbot.State.PickHistory = map[string]time.Time{
+		"U1": time.Now(),
+		"U2": time.Now(),
+	}
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, "I choose you: <@U3>, <@U4>")
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> pick example-team"))
+	assert.Contains(t, mockbot.lastPicked(), "U3")
+	assert.Contains(t, mockbot.lastPicked(), "U4")
+
+	t.Log("Can pick the requester")
+	mockbot, mocks, mockCtrl = getMockBot(t)
+	defer mockCtrl.Finish()
+	mockbot.State.TeamSettings = map[string]teamSettings{"same-user-team": {IncludeRequester: true}}
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, "I choose you: <@U0>")
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> pick same-user-team"))
+
+	t.Log("Only requests reviews, and tells the team's channel")
+	mockbot, mocks, mockCtrl = getMockBot(t)
+	defer mockCtrl.Finish()
+	mockbot.State.TeamSettings = map[string]teamSettings{
+		"github-user-team": {Reviewers: 2, Assign: assignReviewer, Channel: "C123"},
+	}
+	mocks.GithubClient.EXPECT().GetPullRequestStatus(gomock.Any(), testGithubOrg, "fake-repo", 1).Return(testOpenPR, nil)
+	mocks.GithubClient.EXPECT().AddReviewers(gomock.Any(), testGithubOrg, "fake-repo", 1, []string{"github", "G2Github"})
+	mocks.SlackEvents.EXPECT().PostMessage("C123", "<@U0> asked team github-user-team for a review: <@G1>, <@G2> will look at https://github.com/Clever/fake-repo/pull/1")
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, "Set <@G1>, <@G2> as pull-request reviewer")
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> assign github-user-team for https://github.com/Clever/fake-repo/pull/1"))
+}
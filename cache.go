@@ -0,0 +1,62 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	whoswho "github.com/Clever/who-is-who/go-client"
+)
+
+// teamCache is a snapshot of the directory's teams, together with pickabot's overrides and flair.
+// A teamCache is never changed once it's in use, so it can be read without locks:
+// changes are made to a copy, which then replaces it.
+type teamCache struct {
+	TeamToTeamMembers map[string][]whoswho.User
+	TeamOverrides     []Override
+	UserFlair         map[string]string
+	LastRefresh       time.Time
+}
+
+// clone copies the maps and slices of c, so they can be changed without affecting c
+func (c *teamCache) clone() *teamCache {
+	next := &teamCache{
+		TeamToTeamMembers: map[string][]whoswho.User{},
+		TeamOverrides:     append([]Override{}, c.TeamOverrides...),
+		UserFlair:         map[string]string{},
+		LastRefresh:       c.LastRefresh,
+	}
+	for team, members := range c.TeamToTeamMembers {
+		next.TeamToTeamMembers[team] = append([]whoswho.User{}, members...)
+	}
+	for slackID, flair := range c.UserFlair {
+		next.UserFlair[slackID] = flair
+	}
+	return next
+}
+
+var emptyTeamCache = &teamCache{}
+
+// cache returns the current snapshot, which must not be changed
+func (bot *Bot) cache() *teamCache {
+	if c := bot.cachedTeams.Load(); c != nil {
+		return c
+	}
+	return emptyTeamCache
+}
+
+// cacheWriteLock makes changes to the cache one at a time, so none are lost
+var cacheWriteLock = &sync.Mutex{}
+
+// updateCache applies update to a copy of the current snapshot, then makes the copy current.
+// If update returns an error, the snapshot is left as it was.
+func (bot *Bot) updateCache(update func(*teamCache) error) error {
+	cacheWriteLock.Lock()
+	defer cacheWriteLock.Unlock()
+
+	next := bot.cache().clone()
+	if err := update(next); err != nil {
+		return err
+	}
+	bot.cachedTeams.Store(next)
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	whoswho "github.com/Clever/who-is-who/go-client"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateCacheIsCopyOnWrite(t *testing.T) {
+	mockbot, _, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+
+	before := mockbot.cache()
+	setCache(mockbot, func(c *teamCache) {
+		c.TeamToTeamMembers["example-team"] = append(c.TeamToTeamMembers["example-team"], whoswho.User{SlackID: "U5"})
+		c.UserFlair["U5"] = ":wave:"
+	})
+	assert.Equal(t, 4, len(before.TeamToTeamMembers["example-team"]))
+	assert.Equal(t, 0, len(before.UserFlair))
+	assert.Equal(t, 5, len(mockbot.cache().TeamToTeamMembers["example-team"]))
+
+	t.Log("A failed update leaves the cache as it was")
+	err := mockbot.updateCache(func(c *teamCache) error {
+		c.UserFlair["U5"] = ":x:"
+		return fmt.Errorf("failed")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, ":wave:", mockbot.cache().UserFlair["U5"])
+}
+
+// TestConcurrentPicksAndRefreshes is meant to be run with -race
+func TestConcurrentPicksAndRefreshes(t *testing.T) {
+	mockbot, mocks, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+	mockbot.AdminSlackIDs = []string{testUserID}
+	mockbot.RandomSource = &lockedSource{src: mockbot.RandomSource}
+
+	users := []whoswho.User{}
+	for _, id := range []string{"U1", "U2", "U3", "U4"} {
+		users = append(users, whoswho.User{SlackID: id, Active: true, Team: "Engineering - Example Team"})
+	}
+	mocks.WhoIsWhoClient.EXPECT().GetUserList().Return(users, nil).AnyTimes()
+	mocks.WhoIsWhoClient.EXPECT().UserBySlackID(gomock.Any()).AnyTimes()
+	mocks.WhoIsWhoClient.EXPECT().UpsertUser("pickabot", gomock.Any()).AnyTimes()
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, gomock.Any()).AnyTimes()
+	mocks.SlackAPI.EXPECT().GetUserInfo(gomock.Any()).Return(makeSlackUser("user"), nil).AnyTimes()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		for _, message := range []string{
+			"<@U1234> refresh",
+			"<@U1234> pick example-team",
+			"<@U1234> who is example-team",
+			"<@U1234> add <@U5> to example-team",
+			"<@U1234> add flair :dance:",
+			"<@U1234> remove flair",
+			"<@U1234> list teams",
+		} {
+			wg.Add(1)
+			go func(message string) {
+				defer wg.Done()
+				mockbot.DecodeMessage(makeSlackMessage(message))
+			}(message)
+		}
+	}
+	wg.Wait()
+
+	assert.Equal(t, 4, len(mockbot.cache().TeamToTeamMembers["example-team"]))
+	assert.Equal(t, 1, len(mockbot.cache().TeamOverrides))
+}
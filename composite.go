@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Clever/kayvee-go/logger"
+	"github.com/slack-go/slack/slackevents"
+)
+
+var compositeSeparatorRegex = regexp.MustCompile(`\s*(?:\+|,|&|\band\b)\s*`)
+
+// compositeMembers returns the teams that make up a composite team, or nil if team isn't composite
+func (bot *Bot) compositeMembers(team string) []string {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+
+	return bot.State.Composites[team]
+}
+
+// compositeTeams returns the names of all composite teams
+func (bot *Bot) compositeTeams() []string {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+
+	teams := []string{}
+	for team := range bot.State.Composites {
+		teams = append(teams, team)
+	}
+	sort.Strings(teams)
+	return teams
+}
+
+// defineComposite creates or replaces a composite team made of the teams listed in members, e.g. "api + data + infra"
+func (bot *Bot) defineComposite(ev *slackevents.MessageEvent, name, members string) {
+	bot.Logger.InfoD("define-composite", logger.M{"team": name, "members": members, "user": ev.User})
+
+	teams := []string{}
+	for _, member := range compositeSeparatorRegex.Split(strings.TrimSpace(members), -1) {
+		match := teamRegex.FindStringSubmatch(member)
+		if len(match) < 3 {
+			bot.postCompositeMessage(ev, fmt.Sprintf("Sorry, %s isn't a team name", member))
+			return
+		}
+		actualTeamName, err := bot.findMatchingTeam(match[2])
+		if err != nil {
+			bot.reportTeamLookupError(ev, err, func(team string) {
+				bot.defineComposite(ev, name, strings.Replace(members, member, team, 1))
+			})
+			return
+		}
+		if actualTeamName == name || bot.compositeMembers(actualTeamName) != nil {
+			bot.postCompositeMessage(ev, fmt.Sprintf("Sorry, %s is itself a composite team, and composite teams can't contain each other", actualTeamName))
+			return
+		}
+		if !containsString(teams, actualTeamName) {
+			teams = append(teams, actualTeamName)
+		}
+	}
+
+	// a name that's only used by overrides can become a composite team, keeping those overrides
+	if _, ok := bot.cache().TeamToTeamMembers[name]; ok {
+		bot.postCompositeMessage(ev, fmt.Sprintf("Sorry, there's already a team called %s", name))
+		return
+	}
+	if _, isAlias := bot.teamForAlias(name); isAlias {
+		bot.postCompositeMessage(ev, fmt.Sprintf("Sorry, %s is already an alias", name))
+		return
+	}
+
+	err := bot.updateState(func(state *botState) {
+		state.Composites[name] = teams
+	})
+	if err != nil {
+		bot.Logger.ErrorD("define-composite-error", logger.M{"error": err.Error(), "event-text": ev.Text})
+		bot.postCompositeMessage(ev, fmt.Sprintf("Sorry, I couldn't save team %s: %s", name, err))
+		return
+	}
+	bot.postCompositeMessage(ev, fmt.Sprintf("OK, team %s is now %s", name, strings.Join(teams, " + ")))
+}
+
+func (bot *Bot) removeComposite(ev *slackevents.MessageEvent, name string) {
+	bot.Logger.InfoD("remove-composite", logger.M{"team": name, "user": ev.User})
+
+	if bot.compositeMembers(name) == nil {
+		bot.postCompositeMessage(ev, fmt.Sprintf("Sorry, %s isn't a composite team", name))
+		return
+	}
+	err := bot.updateState(func(state *botState) {
+		delete(state.Composites, name)
+	})
+	if err != nil {
+		bot.Logger.ErrorD("remove-composite-error", logger.M{"error": err.Error(), "event-text": ev.Text})
+		bot.postCompositeMessage(ev, fmt.Sprintf("Sorry, I couldn't remove team %s: %s", name, err))
+		return
+	}
+	bot.postCompositeMessage(ev, fmt.Sprintf("OK, team %s is gone", name))
+}
+
+func (bot *Bot) postCompositeMessage(ev *slackevents.MessageEvent, text string) {
+	err := bot.SlackEventsService.PostMessage(ev.Channel, text)
+	if err != nil {
+		bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Clever/kayvee-go/logger"
+	whoswho "github.com/Clever/who-is-who/go-client"
+)
+
+// teamChange is who joined and left a team, by Slack ID
+type teamChange struct {
+	Joined []string `json:"joined,omitempty"`
+	Left   []string `json:"left,omitempty"`
+}
+
+// overrideChangeNote describes how one user's override for one team changed
+type overrideChangeNote struct {
+	SlackID string `json:"slack_id"`
+	Team    string `json:"team"`
+	// Before and After are "added", "removed" or "" for no override
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// cacheDiff is what changed between two snapshots of the cache
+type cacheDiff struct {
+	Teams map[string]teamChange `json:"teams,omitempty"`
+	// Inactive users were on a team before, and the directory now marks them inactive
+	Inactive  []string             `json:"inactive,omitempty"`
+	Overrides []overrideChangeNote `json:"overrides,omitempty"`
+}
+
+// diffCaches works out what changed between before and after. Teams in renames,
+// which maps old to new names, are compared under their new name. inactive is the Slack IDs
+// of users the directory marks inactive.
+func diffCaches(before, after *teamCache, renames map[string]string, inactive []string) cacheDiff {
+	diff := cacheDiff{Teams: map[string]teamChange{}}
+
+	beforeTeams := map[string][]whoswho.User{}
+	for team, members := range before.TeamToTeamMembers {
+		if newTeam, ok := renames[team]; ok {
+			team = newTeam
+		}
+		beforeTeams[team] = members
+	}
+
+	teams := map[string]struct{}{}
+	for team := range beforeTeams {
+		teams[team] = struct{}{}
+	}
+	for team := range after.TeamToTeamMembers {
+		teams[team] = struct{}{}
+	}
+	for team := range teams {
+		oldIDs, newIDs := userIDSet(beforeTeams[team]), userIDSet(after.TeamToTeamMembers[team])
+		change := teamChange{Joined: missingIDs(newIDs, oldIDs), Left: missingIDs(oldIDs, newIDs)}
+		if len(change.Joined) > 0 || len(change.Left) > 0 {
+			diff.Teams[team] = change
+		}
+	}
+
+	wasOnATeam := map[string]struct{}{}
+	for _, members := range before.TeamToTeamMembers {
+		for id := range userIDSet(members) {
+			wasOnATeam[id] = struct{}{}
+		}
+	}
+	diff.Inactive = []string{}
+	for _, id := range inactive {
+		if _, ok := wasOnATeam[id]; ok {
+			diff.Inactive = append(diff.Inactive, id)
+		}
+	}
+	sort.Strings(diff.Inactive)
+
+	beforeOverrides, afterOverrides := overrideStates(before.TeamOverrides), overrideStates(after.TeamOverrides)
+	keys := map[[2]string]struct{}{}
+	for key := range beforeOverrides {
+		keys[key] = struct{}{}
+	}
+	for key := range afterOverrides {
+		keys[key] = struct{}{}
+	}
+	for key := range keys {
+		if beforeOverrides[key] != afterOverrides[key] {
+			diff.Overrides = append(diff.Overrides, overrideChangeNote{
+				SlackID: key[0], Team: key[1], Before: beforeOverrides[key], After: afterOverrides[key],
+			})
+		}
+	}
+	sort.Slice(diff.Overrides, func(i, j int) bool {
+		if diff.Overrides[i].Team != diff.Overrides[j].Team {
+			return diff.Overrides[i].Team < diff.Overrides[j].Team
+		}
+		return diff.Overrides[i].SlackID < diff.Overrides[j].SlackID
+	})
+	return diff
+}
+
+func (d cacheDiff) empty() bool {
+	return len(d.Teams) == 0 && len(d.Inactive) == 0 && len(d.Overrides) == 0
+}
+
+// lines describes every change, one per line
+func (d cacheDiff) lines() []string {
+	lines := []string{}
+	for _, team := range d.changedTeams() {
+		lines = append(lines, d.teamLines(team)...)
+	}
+	if len(d.Inactive) > 0 {
+		lines = append(lines, fmt.Sprintf("Now inactive in the directory: %s", mentionIDs(d.Inactive)))
+	}
+	return lines
+}
+
+// teamLines describes the changes to one team, e.g. "Team infra: <@U1> joined; <@U2> left", then its overrides
+func (d cacheDiff) teamLines(team string) []string {
+	lines := []string{}
+	if change, ok := d.Teams[team]; ok {
+		parts := []string{}
+		if len(change.Joined) > 0 {
+			parts = append(parts, mentionIDs(change.Joined)+" joined")
+		}
+		if len(change.Left) > 0 {
+			parts = append(parts, mentionIDs(change.Left)+" left")
+		}
+		lines = append(lines, fmt.Sprintf("Team %s: %s", team, strings.Join(parts, "; ")))
+	}
+	for _, o := range d.Overrides {
+		if o.Team != team {
+			continue
+		}
+		if o.After == "" {
+			lines = append(lines, fmt.Sprintf("Team %s: <@%s> is no longer %s by an override", team, o.SlackID, o.Before))
+		} else {
+			lines = append(lines, fmt.Sprintf("Team %s: <@%s> is now %s by an override", team, o.SlackID, o.After))
+		}
+	}
+	return lines
+}
+
+// changedTeams returns every team with a change, sorted
+func (d cacheDiff) changedTeams() []string {
+	teams := []string{}
+	for team := range d.Teams {
+		teams = append(teams, team)
+	}
+	for _, o := range d.Overrides {
+		if !containsString(teams, o.Team) {
+			teams = append(teams, o.Team)
+		}
+	}
+	sort.Strings(teams)
+	return teams
+}
+
+// reportCacheDiff logs what changed in a refresh, posts each team's changes to its channel, and returns every change
+func (bot *Bot) reportCacheDiff(diff cacheDiff) []string {
+	if diff.empty() {
+		return nil
+	}
+	bot.Logger.InfoD("team-cache-diff", logger.M{"teams": diff.Teams, "inactive": diff.Inactive, "overrides": diff.Overrides})
+
+	for _, team := range diff.changedTeams() {
+		channel := bot.teamSettings(team).Channel
+		if channel == "" {
+			continue
+		}
+		err := bot.SlackEventsService.PostMessage(channel, strings.Join(diff.teamLines(team), "\n"))
+		if err != nil {
+			bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
+		}
+	}
+	return diff.lines()
+}
+
+func userIDSet(users []whoswho.User) map[string]struct{} {
+	ids := map[string]struct{}{}
+	for _, u := range users {
+		ids[u.SlackID] = struct{}{}
+	}
+	return ids
+}
+
+// missingIDs returns the sorted IDs in from that aren't in other
+func missingIDs(from, other map[string]struct{}) []string {
+	missing := []string{}
+	for id := range from {
+		if _, ok := other[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// overrideStates maps (Slack ID, team) to "added" or "removed"
+func overrideStates(overrides []Override) map[[2]string]string {
+	states := map[[2]string]string{}
+	for _, o := range overrides {
+		state := "removed"
+		if o.Include {
+			state = "added"
+		}
+		states[[2]string{o.User.SlackID, o.Team}] = state
+	}
+	return states
+}
+
+func mentionIDs(ids []string) string {
+	mentions := []string{}
+	for _, id := range ids {
+		mentions = append(mentions, fmt.Sprintf("<@%s>", id))
+	}
+	return strings.Join(mentions, ", ")
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+
+	whoswho "github.com/Clever/who-is-who/go-client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffCaches(t *testing.T) {
+	before := &teamCache{
+		TeamToTeamMembers: map[string][]whoswho.User{
+			"infra": {{SlackID: "U1"}, {SlackID: "U2"}},
+			"old":   {{SlackID: "U3"}},
+			"data":  {{SlackID: "U4"}},
+		},
+		TeamOverrides: []Override{
+			{User: whoswho.User{SlackID: "U5"}, Team: "infra", Include: true},
+			{User: whoswho.User{SlackID: "U6"}, Team: "data", Include: true},
+		},
+	}
+	after := &teamCache{
+		TeamToTeamMembers: map[string][]whoswho.User{
+			"infra": {{SlackID: "U1"}, {SlackID: "U7"}},
+			"new":   {{SlackID: "U3"}},
+			"data":  {{SlackID: "U4"}},
+		},
+		TeamOverrides: []Override{
+			{User: whoswho.User{SlackID: "U5"}, Team: "infra", Include: false},
+		},
+	}
+
+	// U8 was already inactive, and wasn't on a team
+	diff := diffCaches(before, after, map[string]string{"old": "new"}, []string{"U8", "U2"})
+	assert.Equal(t, map[string]teamChange{"infra": {Joined: []string{"U7"}, Left: []string{"U2"}}}, diff.Teams)
+	assert.Equal(t, []string{"U2"}, diff.Inactive)
+	assert.Equal(t, []string{
+		"Team data: <@U6> is no longer added by an override",
+		"Team infra: <@U7> joined; <@U2> left",
+		"Team infra: <@U5> is now removed by an override",
+		"Now inactive in the directory: <@U2>",
+	}, diff.lines())
+
+	assert.True(t, diffCaches(after, after, nil, nil).empty())
+}
+
+func TestRefreshPostsChangesToTeamChannels(t *testing.T) {
+	mockbot, mocks, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+	mockbot.State.TeamSettings = map[string]teamSettings{"example-team": {Channel: "C123"}}
+
+	users := []whoswho.User{}
+	for _, id := range []string{"U1", "U2", "U3", "U5"} {
+		users = append(users, whoswho.User{SlackID: id, Active: true, Team: "Engineering - Example Team"})
+	}
+	for _, u := range []whoswho.User{testGithubUser, {SlackID: "G2", Github: "G2Github"}} {
+		u.Active = true
+		u.Team = "Engineering - Github User Team"
+		users = append(users, u)
+	}
+	users = append(users, whoswho.User{SlackID: testUserID, Active: true, Team: "Engineering - Same User Team"})
+	users = append(users, whoswho.User{SlackID: "U4", Active: false, Team: "Engineering - Example Team"})
+	mocks.WhoIsWhoClient.EXPECT().GetUserList().Return(users, nil)
+
+	mocks.SlackEvents.EXPECT().PostMessage("C123", "Team example-team: <@U5> joined; <@U4> left")
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, "refreshed user cache\n"+
+		"Team example-team: <@U5> joined; <@U4> left\n"+
+		"Now inactive in the directory: <@U4>")
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> refresh"))
+}
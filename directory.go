@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Clever/kayvee-go/logger"
+	whoswho "github.com/Clever/who-is-who/go-client"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// directoryProvider is where pickabot gets its users, teams, team overrides and flair from
+type directoryProvider interface {
+	// Load returns every team with its members, all team overrides, each user's flair (by Slack ID), and
+	// which users are inactive
+	Load() (directorySnapshot, error)
+	// UserBySlackID returns a user, including their pickabot overrides and flair
+	UserBySlackID(slackID string) (whoswho.User, error)
+	// UpdateUser saves a user's pickabot overrides and flair
+	UpdateUser(user whoswho.User) error
+}
+
+// whoIsWhoDirectory loads the directory from who-is-who
+type whoIsWhoDirectory struct {
+	Client whoIsWhoClientIface
+	Logger logger.KayveeLogger
+	// Rules decide which team each user is on, and default to defaultTeamMappingRules
+	Rules []teamMappingRule
+
+	// collisionsOnce reports who-is-who teams that Rules map to the same team, the first time who-is-who loads
+	collisionsOnce sync.Once
+}
+
+// Load builds teams from every active who-is-who user
+func (d *whoIsWhoDirectory) Load() (directorySnapshot, error) {
+	users, err := d.Client.GetUserList()
+	if err != nil {
+		return directorySnapshot{}, err
+	}
+	d.collisionsOnce.Do(func() {
+		for team, whoIsWhoTeams := range teamMappingCollisions(users, d.Rules) {
+			d.Logger.WarnD("team-mapping-collision", logger.M{"team": team, "who-is-who-teams": whoIsWhoTeams})
+		}
+	})
+	teams, overrides, userFlair := buildTeams(users, d.Rules)
+	inactive := []string{}
+	for _, u := range users {
+		if !u.Active && u.SlackID != "" {
+			inactive = append(inactive, u.SlackID)
+		}
+	}
+	return directorySnapshot{Teams: teams, Overrides: overrides, UserFlair: userFlair, Inactive: inactive}, nil
+}
+
+// UserBySlackID looks up a user in who-is-who
+func (d *whoIsWhoDirectory) UserBySlackID(slackID string) (whoswho.User, error) {
+	return d.Client.UserBySlackID(slackID)
+}
+
+// UpdateUser upserts the user into who-is-who
+func (d *whoIsWhoDirectory) UpdateUser(user whoswho.User) error {
+	_, err := d.Client.UpsertUser("pickabot", user)
+	return err
+}
+
+// staticUser is a user in a static directory file
+type staticUser struct {
+	SlackID   string           `json:"slack_id" yaml:"slack_id"`
+	Slack     string           `json:"slack,omitempty" yaml:"slack,omitempty"`
+	Github    string           `json:"github,omitempty" yaml:"github,omitempty"`
+	Email     string           `json:"email,omitempty" yaml:"email,omitempty"`
+	FirstName string           `json:"first_name,omitempty" yaml:"first_name,omitempty"`
+	LastName  string           `json:"last_name,omitempty" yaml:"last_name,omitempty"`
+	Teams     []string         `json:"teams,omitempty" yaml:"teams,omitempty"`
+	Flair     string           `json:"flair,omitempty" yaml:"flair,omitempty"`
+	Overrides []staticOverride `json:"overrides,omitempty" yaml:"overrides,omitempty"`
+}
+
+// staticOverride is a team override in a static directory file
+type staticOverride struct {
+	Team    string `json:"team" yaml:"team"`
+	Include bool   `json:"include" yaml:"include"`
+	// Until is when the override expires, as a Unix timestamp. Zero means never.
+	Until int64 `json:"until,omitempty" yaml:"until,omitempty"`
+}
+
+// staticDirectoryFile is the format of a static directory file
+type staticDirectoryFile struct {
+	Users []staticUser `json:"users" yaml:"users"`
+}
+
+// staticDirectory loads the directory from a YAML or JSON file, so pickabot can run without who-is-who.
+// Users' teams are listed in the file as pickabot team names.
+// Overrides and flair changed through pickabot are kept in memory only, and are lost on restart.
+type staticDirectory struct {
+	lock  sync.Mutex
+	users []staticUser
+}
+
+// newStaticDirectory reads a directory file, parsing it as YAML if it ends in .yml or .yaml and as JSON otherwise
+func newStaticDirectory(path string) (*staticDirectory, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file := staticDirectoryFile{}
+	switch filepath.Ext(path) {
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(data, &file)
+	default:
+		err = json.Unmarshal(data, &file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing directory file %s: %s", path, err)
+	}
+
+	for idx, u := range file.Users {
+		if u.SlackID == "" {
+			return nil, fmt.Errorf("user %d in directory file %s has no slack_id", idx, path)
+		}
+	}
+	return &staticDirectory{users: file.Users}, nil
+}
+
+// Load builds teams from the users in the file, who are all active
+func (d *staticDirectory) Load() (directorySnapshot, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	teams := map[string][]whoswho.User{}
+	overrides := []Override{}
+	userFlair := map[string]string{}
+	for _, su := range d.users {
+		u := su.user()
+		for _, team := range su.Teams {
+			teams[team] = append(teams[team], u)
+		}
+		for _, to := range su.Overrides {
+			override := Override{User: u, Team: to.Team, Include: to.Include}
+			if to.Until > 0 {
+				override.Until = time.Unix(to.Until, 0)
+			}
+			overrides = append(overrides, override)
+		}
+		if su.Flair != "" {
+			userFlair[su.SlackID] = su.Flair
+		}
+	}
+	return directorySnapshot{Teams: teams, Overrides: overrides, UserFlair: userFlair}, nil
+}
+
+// UserBySlackID finds a user in the file
+func (d *staticDirectory) UserBySlackID(slackID string) (whoswho.User, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for _, su := range d.users {
+		if su.SlackID == slackID {
+			return su.user(), nil
+		}
+	}
+	return whoswho.User{}, fmt.Errorf("no user with Slack ID %s in the directory", slackID)
+}
+
+// UpdateUser changes a user's overrides and flair, until pickabot restarts
+func (d *staticDirectory) UpdateUser(user whoswho.User) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for idx := range d.users {
+		su := &d.users[idx]
+		if su.SlackID != user.SlackID {
+			continue
+		}
+		su.Flair = user.Pickabot.Flair
+		su.Overrides = []staticOverride{}
+		for _, to := range user.Pickabot.TeamOverrides {
+			su.Overrides = append(su.Overrides, staticOverride{Team: to.Team, Include: to.Include, Until: to.Until})
+		}
+		return nil
+	}
+	return fmt.Errorf("no user with Slack ID %s in the directory", user.SlackID)
+}
+
+// user converts su to the user model used by the rest of pickabot
+func (su staticUser) user() whoswho.User {
+	u := whoswho.User{
+		SlackID:   su.SlackID,
+		Slack:     su.Slack,
+		Github:    su.Github,
+		Email:     su.Email,
+		FirstName: su.FirstName,
+		LastName:  su.LastName,
+		Active:    true,
+		Pickabot:  whoswho.Pickabot{Flair: su.Flair},
+	}
+	if len(su.Teams) > 0 {
+		u.Team = su.Teams[0]
+	}
+	for _, to := range su.Overrides {
+		u.Pickabot.TeamOverrides = append(u.Pickabot.TeamOverrides, whoswho.PickabotTeamOverride{Team: to.Team, Include: to.Include, Until: to.Until})
+	}
+	return u
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	whoswho "github.com/Clever/who-is-who/go-client"
+	"github.com/stretchr/testify/assert"
+)
+
+const testDirectoryYAML = `
+users:
+  - slack_id: U1
+    github: user-one
+    first_name: User
+    last_name: One
+    teams: [infra, data]
+    flair: ":tada:"
+  - slack_id: U2
+    github: user-two
+    teams: [infra]
+    overrides:
+      - team: data
+        include: true
+        until: 1700000000
+`
+
+const testDirectoryJSON = `{"users": [{"slack_id": "U1", "github": "user-one", "teams": ["infra"]}]}`
+
+func writeDirectoryFile(t *testing.T, name, contents string) string {
+	dir, err := ioutil.TempDir("", "pickabot-directory")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, name)
+	assert.NoError(t, ioutil.WriteFile(path, []byte(contents), 0644))
+	return path
+}
+
+func TestStaticDirectory(t *testing.T) {
+	directory, err := newStaticDirectory(writeDirectoryFile(t, "directory.yml", testDirectoryYAML))
+	assert.NoError(t, err)
+
+	snapshot, err := directory.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"U1", "U2"}, slackIDs(snapshot.Teams["infra"]))
+	assert.Equal(t, []string{"U1"}, slackIDs(snapshot.Teams["data"]))
+	assert.Equal(t, map[string]string{"U1": ":tada:"}, snapshot.UserFlair)
+	assert.Equal(t, 1, len(snapshot.Overrides))
+	assert.Equal(t, "U2", snapshot.Overrides[0].User.SlackID)
+	assert.Equal(t, "data", snapshot.Overrides[0].Team)
+	assert.True(t, snapshot.Overrides[0].Include)
+	assert.Equal(t, time.Unix(1700000000, 0), snapshot.Overrides[0].Until)
+
+	t.Log("Looks up users by Slack ID")
+	user, err := directory.UserBySlackID("U1")
+	assert.NoError(t, err)
+	assert.Equal(t, "user-one", user.Github)
+	assert.Equal(t, ":tada:", user.Pickabot.Flair)
+	_, err = directory.UserBySlackID("U3")
+	assert.Error(t, err)
+
+	t.Log("Updates overrides and flair in memory")
+	user.Pickabot.Flair = ""
+	user.Pickabot.TeamOverrides = []whoswho.PickabotTeamOverride{{Team: "data", Include: false}}
+	assert.NoError(t, directory.UpdateUser(user))
+	snapshot, err = directory.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{}, snapshot.UserFlair)
+	assert.Equal(t, 2, len(snapshot.Overrides))
+	assert.Error(t, directory.UpdateUser(whoswho.User{SlackID: "U3"}))
+}
+
+func TestStaticDirectoryFormats(t *testing.T) {
+	directory, err := newStaticDirectory(writeDirectoryFile(t, "directory.json", testDirectoryJSON))
+	assert.NoError(t, err)
+	snapshot, err := directory.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"U1"}, slackIDs(snapshot.Teams["infra"]))
+
+	_, err = newStaticDirectory(writeDirectoryFile(t, "directory.json", testDirectoryYAML))
+	assert.Error(t, err)
+	_, err = newStaticDirectory(writeDirectoryFile(t, "directory.yaml", "users:\n  - github: no-slack-id\n"))
+	assert.Error(t, err)
+}
+
+func slackIDs(users []whoswho.User) []string {
+	ids := []string{}
+	for _, u := range users {
+		ids = append(ids, u.SlackID)
+	}
+	return ids
+}
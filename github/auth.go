@@ -63,20 +63,22 @@ func (a *AppClient) generateNewJWT() error {
 	return nil
 }
 
-func (a *AppClient) generateGithubAccessToken() error {
+// generateGithubAccessToken exchanges a JWT for an installation access token.
+// It must be called with refreshLock held.
+func (a *AppClient) generateGithubAccessToken() (Token, error) {
 	// check and re-generate JWT
 	if a.jwt.IsExpired() {
 		err := a.generateNewJWT()
 		if err != nil {
-			return fmt.Errorf("error generating JWT for GitHub access: %s", err)
+			return Token{}, fmt.Errorf("error generating JWT for GitHub access: %s", err)
 		}
 	}
 
 	// ask for a token
-	client := &http.Client{}
-	req, err := http.NewRequest("POST", fmt.Sprintf("https://api.github.com/app/installations/%s/access_tokens", a.InstallationID), nil)
+	client := a.httpClient()
+	req, err := http.NewRequest("POST", fmt.Sprintf("%sapp/installations/%s/access_tokens", a.baseURL(), a.InstallationID), nil)
 	if err != nil {
-		return err
+		return Token{}, err
 	}
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", a.jwt.Token))
 	// We need to pass this "machine-man" media type for our expected format
@@ -85,27 +87,26 @@ func (a *AppClient) generateGithubAccessToken() error {
 	req.Header.Set("Accept", "application/vnd.github.machine-man-preview+json")
 	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return Token{}, err
 	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return Token{}, err
 	}
 	// additionally check status code
 	if resp.StatusCode >= 400 {
-		return fmt.Errorf("received failing status code: %d", resp.StatusCode)
+		return Token{}, fmt.Errorf("received failing status code: %d", resp.StatusCode)
 	}
 
 	// parse response
 	var outputToken Token
 	err = json.Unmarshal(body, &outputToken)
 	if err != nil {
-		return err
+		return Token{}, err
 	}
 
 	// yay we have a new token!
-	a.githubAccessToken = outputToken
-	a.Logger.InfoD("generated-bearer-token", logger.M{"expiration": a.githubAccessToken.Expiration})
-	return nil
+	a.Logger.InfoD("generated-bearer-token", logger.M{"expiration": outputToken.Expiration})
+	return outputToken, nil
 }
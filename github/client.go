@@ -2,7 +2,12 @@ package github
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/Clever/kayvee-go/logger"
@@ -10,16 +15,45 @@ import (
 	"golang.org/x/oauth2"
 )
 
-var (
-	// Github's rate limit for authenticated requests is 5000 QPH = 83.3 QPM = 1.38 QPS = 720ms/query
-	// We also use a global limiter to prevent concurrent requests, which trigger Github's abuse detection
-	githubLimiter = time.NewTicker(720 * time.Millisecond)
-)
-
 // AppClientIface represents the endpoints available to a github application
 type AppClientIface interface {
 	AddAssignees(ctx context.Context, owner, repo string, number int, assignees []string) (*github.Issue, *github.Response, error)
 	AddReviewers(ctx context.Context, owner, repo string, number int, reviewers []string) (*github.PullRequest, *github.Response, error)
+	RemoveTeamReviewers(ctx context.Context, owner, repo string, number int, teams []string) (*github.Response, error)
+	GetPullRequestStatus(ctx context.Context, owner, repo string, number int) (PullRequestStatus, error)
+	ListPullRequestFiles(ctx context.Context, owner, repo string, number int) ([]*github.CommitFile, error)
+	GetFileContents(ctx context.Context, owner, repo, path, ref string) ([]byte, error)
+	TokenExpiry() time.Time
+}
+
+const (
+	// tokenRefreshBefore is how long before an access token expires that it's replaced
+	tokenRefreshBefore = 5 * time.Minute
+	// minTokenRetryBackoff is how long to wait to retry a failed token refresh, doubling each time up to maxTokenRetryBackoff
+	minTokenRetryBackoff = 10 * time.Second
+	maxTokenRetryBackoff = 2 * time.Minute
+)
+
+// DefaultBaseURL is GitHub.com's API
+const DefaultBaseURL = "https://api.github.com/"
+
+// ErrNotFound is returned by GetFileContents when there's no such file
+var ErrNotFound = errors.New("file not found")
+
+// ErrNoAccess is returned by GetPullRequestStatus when GitHub won't show the pull request: either it doesn't
+// exist, or the app's installation doesn't have access to its repo
+var ErrNoAccess = errors.New("pull request not found, or not accessible to the app")
+
+// PullRequestStatus is what pickabot checks about a pull request before assigning it: whether it's open, merged
+// or a draft, and its base branch and author. go-github's PullRequest doesn't have Draft, so it's read from the
+// API's JSON directly.
+type PullRequestStatus struct {
+	// State is "open" or "closed"
+	State  string                    `json:"state"`
+	Merged bool                      `json:"merged"`
+	Draft  bool                      `json:"draft"`
+	Base   *github.PullRequestBranch `json:"base"`
+	User   *github.User              `json:"user"`
 }
 
 // AppClient is an implementation of the AppClientIface
@@ -29,52 +63,233 @@ type AppClient struct {
 	InstallationID string
 	Logger         logger.KayveeLogger
 	PrivateKey     []byte
+	// BaseURL is the API's URL, e.g. "https://github.example.com/api/v3/" for GitHub Enterprise Server.
+	// It defaults to DefaultBaseURL.
+	BaseURL string
 
-	jwt               Token
+	// lock guards githubAccessToken and client, which are replaced together when the token is refreshed
+	lock              sync.Mutex
 	githubAccessToken Token
 	client            *github.Client
+	// refreshLock makes token refreshes happen one at a time, and guards jwt
+	refreshLock sync.Mutex
+	jwt         Token
+
+	// limiter keeps every request to GitHub, including for tokens, within its rate limits
+	limiter     *rateLimiter
+	limiterOnce sync.Once
 }
 
 // AddAssignees adds assignees to an issue
 func (a *AppClient) AddAssignees(ctx context.Context, owner, repo string, number int, assignees []string) (*github.Issue, *github.Response, error) {
-	if err := a.checkClient(); err != nil {
+	client, err := a.checkClient()
+	if err != nil {
 		return &github.Issue{}, &github.Response{}, err
 	}
-	return a.client.Issues.AddAssignees(context.Background(), owner, repo, number, assignees)
+	return client.Issues.AddAssignees(context.Background(), owner, repo, number, assignees)
 }
 
 // AddReviewers adds reviewers to a pull request
 func (a *AppClient) AddReviewers(ctx context.Context, owner, repo string, number int, reviewers []string) (*github.PullRequest, *github.Response, error) {
-	if err := a.checkClient(); err != nil {
+	client, err := a.checkClient()
+	if err != nil {
 		return &github.PullRequest{}, &github.Response{}, err
 	}
-	return a.client.PullRequests.RequestReviewers(context.Background(), owner, repo, number, github.ReviewersRequest{
+	return client.PullRequests.RequestReviewers(context.Background(), owner, repo, number, github.ReviewersRequest{
 		Reviewers: reviewers,
 	})
 }
 
-// checkClient validates the current token and re-authenticates if it needs to
-// this should be called BEFORE every call of the github client
-func (a *AppClient) checkClient() error {
-	if a.githubAccessToken.IsExpired() {
-		if err := a.setupNewClient(); err != nil {
-			return err
+// RemoveTeamReviewers removes review requests for teams, by slug, from a pull request
+func (a *AppClient) RemoveTeamReviewers(ctx context.Context, owner, repo string, number int, teams []string) (*github.Response, error) {
+	client, err := a.checkClient()
+	if err != nil {
+		return &github.Response{}, err
+	}
+	return client.PullRequests.RemoveReviewers(context.Background(), owner, repo, number, github.ReviewersRequest{
+		TeamReviewers: teams,
+	})
+}
+
+// GetPullRequestStatus gets whether a pull request is open, merged or a draft, and its base branch and author.
+// It returns ErrNoAccess if GitHub says there's no such pull request, or that the app can't access it.
+func (a *AppClient) GetPullRequestStatus(ctx context.Context, owner, repo string, number int) (PullRequestStatus, error) {
+	status := PullRequestStatus{}
+	client, err := a.checkClient()
+	if err != nil {
+		return status, err
+	}
+	req, err := client.NewRequest("GET", fmt.Sprintf("repos/%s/%s/pulls/%d", owner, repo, number), nil)
+	if err != nil {
+		return status, err
+	}
+	resp, err := client.Do(ctx, req, &status)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return status, ErrNoAccess
+	}
+	// rate limits are also 403s, but go-github reports them as a *github.RateLimitError or *github.AbuseRateLimitError
+	if _, ok := err.(*github.ErrorResponse); ok && resp.StatusCode == http.StatusForbidden {
+		return status, ErrNoAccess
+	}
+	return status, err
+}
+
+// ListPullRequestFiles lists every file a pull request changes
+func (a *AppClient) ListPullRequestFiles(ctx context.Context, owner, repo string, number int) ([]*github.CommitFile, error) {
+	files := []*github.CommitFile{}
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		client, err := a.checkClient()
+		if err != nil {
+			return nil, err
+		}
+		page, resp, err := client.PullRequests.ListFiles(context.Background(), owner, repo, number, opts)
+		if err != nil {
+			return nil, err
 		}
+		files = append(files, page...)
+		if resp.NextPage == 0 {
+			return files, nil
+		}
+		opts.Page = resp.NextPage
 	}
+}
 
-	<-githubLimiter.C
-	return nil
+// GetFileContents gets the contents of the file at path in a repo at ref, e.g. a branch.
+// It returns ErrNotFound if there's no such file.
+func (a *AppClient) GetFileContents(ctx context.Context, owner, repo, path, ref string) ([]byte, error) {
+	client, err := a.checkClient()
+	if err != nil {
+		return nil, err
+	}
+	file, _, resp, err := client.Repositories.GetContents(context.Background(), owner, repo, path, &github.RepositoryContentGetOptions{Ref: ref})
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if file == nil {
+		return nil, fmt.Errorf("%s is a directory", path)
+	}
+	content, err := file.GetContent()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(content), nil
+}
+
+// TokenExpiry returns when the current access token expires, or the zero time if there isn't one yet
+func (a *AppClient) TokenExpiry() time.Time {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.githubAccessToken.Expiration
+}
+
+// checkClient returns a client with a valid token, getting a new token first if it needs to.
+// this should be called BEFORE every call of the github client
+func (a *AppClient) checkClient() (*github.Client, error) {
+	a.lock.Lock()
+	client, token := a.client, a.githubAccessToken
+	a.lock.Unlock()
+	if client != nil && !token.IsExpired() {
+		return client, nil
+	}
+
+	if err := a.refreshToken(tokenRefreshBefore); err != nil {
+		return nil, err
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.client, nil
 }
 
-// setupNewClient sets up authorization for a new github client
-func (a *AppClient) setupNewClient() error {
-	err := a.generateGithubAccessToken()
+// refreshToken gets a new access token and client, unless the current token is valid for longer than before.
+// Only one refresh happens at a time: callers that wait for another refresh use its token. If the refresh
+// fails, the current token and client are kept.
+func (a *AppClient) refreshToken(before time.Duration) error {
+	a.refreshLock.Lock()
+	defer a.refreshLock.Unlock()
+
+	a.lock.Lock()
+	fresh := a.client != nil && time.Until(a.githubAccessToken.Expiration) > before
+	a.lock.Unlock()
+	if fresh {
+		return nil
+	}
+
+	token, err := a.generateGithubAccessToken()
 	if err != nil {
 		return fmt.Errorf("error getting GitHub access token: %s", err)
 	}
-	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: a.githubAccessToken.Token})
-	tc := oauth2.NewClient(context.Background(), ts)
-	a.client = github.NewClient(tc)
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token.Token})
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, a.httpClient())
+	client, err := github.NewEnterpriseClient(a.baseURL(), a.baseURL(), oauth2.NewClient(ctx, ts))
+	if err != nil {
+		return fmt.Errorf("error setting up GitHub client for %s: %s", a.baseURL(), err)
+	}
 
+	a.lock.Lock()
+	a.githubAccessToken, a.client = token, client
+	a.lock.Unlock()
 	return nil
 }
+
+// KeepTokenFresh refreshes the access token tokenRefreshBefore it expires, until ctx is done, so requests
+// don't wait for a new one. Failed refreshes are retried with backoff while the current token is still good.
+func (a *AppClient) KeepTokenFresh(ctx context.Context) {
+	failures := 0
+	for {
+		delay := time.Until(a.TokenExpiry().Add(-tokenRefreshBefore))
+		if failures > 0 {
+			delay = minTokenRetryBackoff << uint(failures-1)
+			if delay > maxTokenRetryBackoff {
+				delay = maxTokenRetryBackoff
+			}
+		}
+		if err := sleepContext(ctx, delay); err != nil {
+			return
+		}
+
+		if err := a.refreshToken(tokenRefreshBefore); err != nil {
+			failures++
+			a.Logger.ErrorD("token-refresh-error", logger.M{"error": err.Error(), "failures": failures, "expiration": a.TokenExpiry()})
+			continue
+		}
+		failures = 0
+	}
+}
+
+// httpClient makes requests through the client's rate limiter
+func (a *AppClient) httpClient() *http.Client {
+	a.limiterOnce.Do(func() {
+		a.limiter = newRateLimiter(http.DefaultTransport)
+	})
+	return &http.Client{Transport: a.limiter}
+}
+
+// baseURL is BaseURL with a trailing slash, or DefaultBaseURL if it isn't set
+func (a *AppClient) baseURL() string {
+	if a.BaseURL == "" {
+		return DefaultBaseURL
+	}
+	return strings.TrimSuffix(a.BaseURL, "/") + "/"
+}
+
+// WebHost returns the host of the website that goes with the API at baseURL, which is where pull requests
+// are linked to: github.com for GitHub.com's API, and the API's own host for GitHub Enterprise Server
+func WebHost(baseURL string) (string, error) {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("%s has no host", baseURL)
+	}
+	if u.Host == "api.github.com" {
+		return "github.com", nil
+	}
+	return u.Host, nil
+}
@@ -0,0 +1,222 @@
+package github
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Clever/kayvee-go/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func testPrivateKey(t *testing.T) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+func TestAppClientBaseURL(t *testing.T) {
+	expiry := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	requests := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch r.URL.Path {
+		case "/api/v3/app/installations/42/access_tokens":
+			assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "Bearer "))
+			json.NewEncoder(w).Encode(Token{Token: "installation-token", Expiration: expiry})
+		case "/api/v3/repos/Clever/fake-repo/pulls/1/requested_reviewers":
+			assert.Equal(t, "Bearer installation-token", r.Header.Get("Authorization"))
+			body, _ := ioutil.ReadAll(r.Body)
+			assert.JSONEq(t, `{"reviewers":["octocat"]}`, string(body))
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"number":1}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := &AppClient{
+		AppID:          "1",
+		InstallationID: "42",
+		Logger:         logger.New("pickabot-test"),
+		PrivateKey:     testPrivateKey(t),
+		BaseURL:        server.URL + "/api/v3",
+	}
+	pr, _, err := client.AddReviewers(context.Background(), "Clever", "fake-repo", 1, []string{"octocat"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, pr.GetNumber())
+	assert.True(t, expiry.Equal(client.TokenExpiry()))
+	assert.Equal(t, []string{
+		"POST /api/v3/app/installations/42/access_tokens",
+		"POST /api/v3/repos/Clever/fake-repo/pulls/1/requested_reviewers",
+	}, requests)
+
+	_, err = client.GetFileContents(context.Background(), "Clever", "fake-repo", ".pickabot.yml", "master")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestGetPullRequestStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/app/installations/42/access_tokens":
+			json.NewEncoder(w).Encode(Token{Token: "installation-token", Expiration: time.Now().Add(time.Hour)})
+		case "/repos/Clever/fake-repo/pulls/1":
+			w.Write([]byte(`{"number":1,"state":"open","merged":false,"draft":true,"base":{"ref":"master"},"user":{"login":"octocat"}}`))
+		case "/repos/Clever/fake-repo/pulls/2":
+			w.Write([]byte(`{"number":2,"state":"closed","merged":true}`))
+		case "/repos/Clever/private-repo/pulls/1":
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"message":"Resource not accessible by integration"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := &AppClient{
+		AppID:          "1",
+		InstallationID: "42",
+		Logger:         logger.New("pickabot-test"),
+		PrivateKey:     testPrivateKey(t),
+		BaseURL:        server.URL,
+	}
+	status, err := client.GetPullRequestStatus(context.Background(), "Clever", "fake-repo", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "open", status.State)
+	assert.True(t, status.Draft)
+	assert.Equal(t, "master", status.Base.GetRef())
+	assert.Equal(t, "octocat", status.User.GetLogin())
+
+	status, err = client.GetPullRequestStatus(context.Background(), "Clever", "fake-repo", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, PullRequestStatus{State: "closed", Merged: true}, status)
+
+	_, err = client.GetPullRequestStatus(context.Background(), "Clever", "fake-repo", 3)
+	assert.Equal(t, ErrNoAccess, err)
+	_, err = client.GetPullRequestStatus(context.Background(), "Clever", "private-repo", 1)
+	assert.Equal(t, ErrNoAccess, err)
+}
+
+func TestWebHost(t *testing.T) {
+	for baseURL, want := range map[string]string{
+		"":                                   "github.com",
+		"https://api.github.com/":            "github.com",
+		"https://github.example.com/api/v3/": "github.example.com",
+		"http://127.0.0.1:8080":              "127.0.0.1:8080",
+	} {
+		host, err := WebHost(baseURL)
+		assert.NoError(t, err)
+		assert.Equal(t, want, host, baseURL)
+	}
+	_, err := WebHost("not a url")
+	assert.Error(t, err)
+}
+
+// tokenServer is a stand-in for GitHub that counts token exchanges, which fail while failTokens is set
+type tokenServer struct {
+	*httptest.Server
+	lock       sync.Mutex
+	exchanges  int
+	failTokens bool
+	tokenTTL   time.Duration
+}
+
+func newTokenServer(tokenTTL time.Duration) *tokenServer {
+	ts := &tokenServer{tokenTTL: tokenTTL}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/app/installations/42/access_tokens" {
+			ts.lock.Lock()
+			defer ts.lock.Unlock()
+			if ts.failTokens {
+				http.Error(w, "bad credentials", http.StatusUnauthorized)
+				return
+			}
+			ts.exchanges++
+			json.NewEncoder(w).Encode(Token{Token: fmt.Sprintf("token-%d", ts.exchanges), Expiration: time.Now().Add(ts.tokenTTL)})
+			return
+		}
+		w.Write([]byte(`{"number":1}`))
+	}))
+	return ts
+}
+
+func (ts *tokenServer) exchangeCount() int {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	return ts.exchanges
+}
+
+func TestAppClientConcurrentTokenRefresh(t *testing.T) {
+	server := newTokenServer(time.Hour)
+	defer server.Close()
+	client := &AppClient{AppID: "1", InstallationID: "42", Logger: logger.New("pickabot-test"), PrivateKey: testPrivateKey(t), BaseURL: server.URL}
+
+	t.Log("Concurrent requests share a single token exchange")
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.GetPullRequestStatus(context.Background(), "Clever", "fake-repo", 1)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, server.exchangeCount())
+}
+
+func TestAppClientFailedRefreshKeepsToken(t *testing.T) {
+	server := newTokenServer(2 * time.Minute)
+	defer server.Close()
+	client := &AppClient{AppID: "1", InstallationID: "42", Logger: logger.New("pickabot-test"), PrivateKey: testPrivateKey(t), BaseURL: server.URL}
+
+	_, err := client.GetPullRequestStatus(context.Background(), "Clever", "fake-repo", 1)
+	assert.NoError(t, err)
+	expiry := client.TokenExpiry()
+
+	t.Log("A token that's about to expire is refreshed early")
+	assert.NoError(t, client.refreshToken(tokenRefreshBefore))
+	assert.Equal(t, 2, server.exchangeCount())
+	assert.True(t, client.TokenExpiry().After(expiry))
+
+	t.Log("If refreshing fails, the token that's still valid is used")
+	server.lock.Lock()
+	server.failTokens = true
+	server.lock.Unlock()
+	assert.Error(t, client.refreshToken(tokenRefreshBefore))
+	_, err = client.GetPullRequestStatus(context.Background(), "Clever", "fake-repo", 1)
+	assert.NoError(t, err)
+
+	t.Log("A token that's valid for long enough isn't refreshed")
+	assert.NoError(t, client.refreshToken(time.Minute))
+}
+
+func TestKeepTokenFresh(t *testing.T) {
+	server := newTokenServer(tokenRefreshBefore + time.Second)
+	defer server.Close()
+	client := &AppClient{AppID: "1", InstallationID: "42", Logger: logger.New("pickabot-test"), PrivateKey: testPrivateKey(t), BaseURL: server.URL}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		client.KeepTokenFresh(ctx)
+		close(done)
+	}()
+
+	// the first token is fetched right away, and replaced a second later since it expires soon
+	assert.Eventually(t, func() bool { return server.exchangeCount() >= 2 }, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+}
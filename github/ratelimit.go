@@ -0,0 +1,232 @@
+package github
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// Github's rate limit for authenticated requests is 5000 QPH = 83.3 QPM = 1.38 QPS = 720ms/query.
+	// This is the pace until GitHub says how much of the limit is left.
+	requestInterval = 720 * time.Millisecond
+	// requestBurst is how many requests can be made at once, before they're spread out
+	requestBurst = 10
+	// maxRetries is how many times a request is retried after a secondary rate limit or server error
+	maxRetries = 3
+	// minRetryBackoff is how long to wait before retrying a server error the first time. It doubles after that.
+	minRetryBackoff = time.Second
+	// maxRateLimitWait is the longest a request waits for a rate limit to reset. Past that, it fails instead.
+	maxRateLimitWait = time.Minute
+	// maxErrorBody is how much of an error response is read to tell whether it's a secondary rate limit
+	maxErrorBody = 64 << 10
+	// secondaryLimitBackoff is how long to wait before retrying a secondary rate limit that didn't say how long
+	secondaryLimitBackoff = time.Minute
+)
+
+// rateLimiter is an http.RoundTripper that keeps to GitHub's rate limits. Requests are let through in small
+// bursts, spread out so the X-RateLimit-Remaining GitHub reported lasts until its X-RateLimit-Reset, and wait
+// while it's used up, or until its Retry-After. Requests that hit a secondary rate limit or a server error are
+// retried with backoff.
+// https://docs.github.com/en/rest/using-the-rest-api/rate-limits-for-the-rest-api
+type rateLimiter struct {
+	base http.RoundTripper
+	// now and sleep are time.Now and sleepContext, except in tests
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+
+	lock sync.Mutex
+	// tokens is how many requests can be made right away. It's negative when requests are waiting.
+	tokens     float64
+	lastRefill time.Time
+	// resumeAt is when GitHub said requests could be made again
+	resumeAt time.Time
+	// remaining is how many requests GitHub said are left until resetAt, less the ones made since. It's -1
+	// until GitHub says.
+	remaining int
+	resetAt   time.Time
+}
+
+func newRateLimiter(base http.RoundTripper) *rateLimiter {
+	return &rateLimiter{
+		base:       base,
+		now:        time.Now,
+		sleep:      sleepContext,
+		tokens:     requestBurst,
+		lastRefill: time.Now(),
+		remaining:  -1,
+	}
+}
+
+// RoundTrip makes a request once the rate limit allows it, retrying it if GitHub asks
+func (l *rateLimiter) RoundTrip(req *http.Request) (*http.Response, error) {
+	// a request can only be retried if its body can be sent again
+	canRetry := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+
+	attemptReq := req
+	for attempt := 0; ; attempt++ {
+		if err := l.wait(req.Context()); err != nil {
+			return nil, err
+		}
+		resp, err := l.base.RoundTrip(attemptReq)
+		if err != nil {
+			return nil, err
+		}
+
+		backoff, retry := l.update(resp, attempt, isSecondaryRateLimit(resp))
+		if !retry || !canRetry || attempt >= maxRetries {
+			return resp, nil
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if err := l.sleep(req.Context(), backoff); err != nil {
+			return nil, err
+		}
+
+		attemptReq = req.Clone(req.Context())
+		if req.GetBody != nil {
+			if attemptReq.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// wait takes a token for a request, waiting for one if there are none left or GitHub asked us to wait
+func (l *rateLimiter) wait(ctx context.Context) error {
+	l.lock.Lock()
+	now := l.now()
+	interval, burst := requestInterval, float64(requestBurst)
+	if l.remaining > 0 && l.resetAt.After(now) {
+		// spread what's left of the limit over the time until it resets
+		interval = l.resetAt.Sub(now) / time.Duration(l.remaining)
+		if float64(l.remaining) < burst {
+			burst = float64(l.remaining)
+		}
+	}
+	l.tokens += float64(now.Sub(l.lastRefill)) / float64(interval)
+	if l.tokens > burst {
+		l.tokens = burst
+	}
+	l.lastRefill = now
+
+	var delay time.Duration
+	if l.tokens < 1 {
+		delay = time.Duration((1 - l.tokens) * float64(interval))
+		// spreading requests out never waits longer than the limit being used up would
+		if delay > maxRateLimitWait {
+			delay = maxRateLimitWait
+		}
+	}
+	if resume := l.resumeAt.Sub(now); resume > delay {
+		delay = resume
+	}
+	if delay > maxRateLimitWait {
+		l.lock.Unlock()
+		return fmt.Errorf("GitHub's rate limit is used up until %s", now.Add(delay).Format(time.RFC1123))
+	}
+	l.tokens--
+	if l.remaining > 0 {
+		l.remaining--
+	}
+	l.lock.Unlock()
+
+	return l.sleep(ctx, delay)
+}
+
+// update records the rate limit GitHub reported in resp. secondary is whether resp is a secondary rate limit.
+// It returns whether to retry the request, and how long to back off first, on top of waiting for the rate limit.
+func (l *rateLimiter) update(resp *http.Response, attempt int, secondary bool) (time.Duration, bool) {
+	now := l.now()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	usedUp := false
+	remaining, remainingErr := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	reset, resetErr := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if remainingErr == nil && resetErr == nil {
+		l.remaining, l.resetAt = remaining, time.Unix(reset, 0)
+		if remaining == 0 {
+			usedUp = true
+			if l.resetAt.After(l.resumeAt) {
+				l.resumeAt = l.resetAt
+			}
+		}
+	}
+	retryAfter, hasRetryAfter := parseRetryAfter(resp.Header.Get("Retry-After"), now)
+	if hasRetryAfter && now.Add(retryAfter).After(l.resumeAt) {
+		l.resumeAt = now.Add(retryAfter)
+	}
+
+	limited := resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests
+	switch {
+	case limited && (hasRetryAfter || usedUp):
+		// only worth retrying if the limit resets soon
+		return 0, l.resumeAt.Sub(now) <= maxRateLimitWait
+	case limited && secondary:
+		// GitHub asks for at least a minute, increasing exponentially, and other requests wait too
+		backoff := secondaryLimitBackoff << uint(attempt)
+		if backoff > maxRateLimitWait {
+			return 0, false
+		}
+		if now.Add(backoff).After(l.resumeAt) {
+			l.resumeAt = now.Add(backoff)
+		}
+		return 0, true
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return minRetryBackoff << uint(attempt), true
+	}
+	return 0, false
+}
+
+// isSecondaryRateLimit reports whether resp is a 403 or 429 for one of GitHub's secondary rate limits, which
+// only say so in their message. resp's body is kept so it can still be read.
+func isSecondaryRateLimit(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return false
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	if err != nil {
+		return false
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+	return bytes.Contains(bytes.ToLower(body), []byte("secondary rate limit"))
+}
+
+// parseRetryAfter parses a Retry-After header, which is a number of seconds or a date
+func parseRetryAfter(header string, now time.Time) (time.Duration, bool) {
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		return at.Sub(now), true
+	}
+	return 0, false
+}
+
+// sleepContext waits for d, or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,208 @@
+package github
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func response(status int, headers map[string]string) *http.Response {
+	resp := &http.Response{StatusCode: status, Header: http.Header{}, Body: ioutil.NopCloser(bytes.NewReader(nil))}
+	for k, v := range headers {
+		resp.Header.Set(k, v)
+	}
+	return resp
+}
+
+// testRateLimiter returns a rateLimiter whose clock only moves when it sleeps, and the sleeps it made
+func testRateLimiter(base roundTripFunc) (*rateLimiter, *[]time.Duration) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	sleeps := []time.Duration{}
+	l := newRateLimiter(base)
+	l.lastRefill = now
+	l.now = func() time.Time { return now }
+	l.sleep = func(ctx context.Context, d time.Duration) error {
+		if d > 0 {
+			sleeps = append(sleeps, d)
+			now = now.Add(d)
+		}
+		return nil
+	}
+	return l, &sleeps
+}
+
+func TestRateLimiterBurst(t *testing.T) {
+	l, sleeps := testRateLimiter(func(req *http.Request) (*http.Response, error) {
+		return response(http.StatusOK, nil), nil
+	})
+
+	t.Log("A burst of requests goes straight through")
+	for i := 0; i < requestBurst; i++ {
+		req, _ := http.NewRequest("GET", "https://api.github.com/", nil)
+		_, err := l.RoundTrip(req)
+		assert.NoError(t, err)
+	}
+	assert.Empty(t, *sleeps)
+
+	t.Log("After that, requests are spread out")
+	req, _ := http.NewRequest("GET", "https://api.github.com/", nil)
+	_, err := l.RoundTrip(req)
+	assert.NoError(t, err)
+	assert.Equal(t, []time.Duration{requestInterval}, *sleeps)
+}
+
+func TestRateLimiterPacesRemaining(t *testing.T) {
+	var reset time.Time
+	remaining := "20"
+	l, sleeps := testRateLimiter(func(req *http.Request) (*http.Response, error) {
+		return response(http.StatusOK, map[string]string{
+			"X-RateLimit-Remaining": remaining,
+			"X-RateLimit-Reset":     strconv.FormatInt(reset.Unix(), 10),
+		}), nil
+	})
+	reset = l.now().Add(200 * time.Second)
+
+	t.Log("After a burst, what's left of the limit is spread over the time until it resets")
+	for i := 0; i <= requestBurst; i++ {
+		req, _ := http.NewRequest("GET", "https://api.github.com/", nil)
+		_, err := l.RoundTrip(req)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []time.Duration{10 * time.Second}, *sleeps)
+
+	t.Log("Bursts are no bigger than what's left")
+	remaining = "2"
+	reset = l.now().Add(20 * time.Second)
+	*sleeps = []time.Duration{}
+	l.remaining = 2
+	l.tokens = requestBurst
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest("GET", "https://api.github.com/", nil)
+		_, err := l.RoundTrip(req)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []time.Duration{10 * time.Second}, *sleeps)
+}
+
+func TestRateLimiterSecondaryLimit(t *testing.T) {
+	statuses := []int{http.StatusForbidden, http.StatusOK}
+	l, sleeps := testRateLimiter(func(req *http.Request) (*http.Response, error) {
+		status := statuses[0]
+		statuses = statuses[1:]
+		resp := response(status, nil)
+		if status == http.StatusForbidden {
+			resp.Body = ioutil.NopCloser(bytes.NewBufferString(`{"message":"You have exceeded a secondary rate limit."}`))
+		}
+		return resp, nil
+	})
+
+	t.Log("Secondary rate limits without a Retry-After are retried after a minute")
+	req, _ := http.NewRequest("GET", "https://api.github.com/", nil)
+	resp, err := l.RoundTrip(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []time.Duration{secondaryLimitBackoff}, *sleeps)
+
+	t.Log("Other 403s aren't retried, and their body can still be read")
+	attempts := 0
+	l, _ = testRateLimiter(func(req *http.Request) (*http.Response, error) {
+		attempts++
+		resp := response(http.StatusForbidden, nil)
+		resp.Body = ioutil.NopCloser(bytes.NewBufferString(`{"message":"Resource not accessible by integration"}`))
+		return resp, nil
+	})
+	resp, err = l.RoundTrip(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, attempts)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, `{"message":"Resource not accessible by integration"}`, string(body))
+}
+
+func TestRateLimiterRetriesServerErrors(t *testing.T) {
+	bodies := []string{}
+	statuses := []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusCreated}
+	l, sleeps := testRateLimiter(func(req *http.Request) (*http.Response, error) {
+		body, _ := ioutil.ReadAll(req.Body)
+		bodies = append(bodies, string(body))
+		status := statuses[0]
+		statuses = statuses[1:]
+		return response(status, nil), nil
+	})
+
+	req, _ := http.NewRequest("POST", "https://api.github.com/", bytes.NewBufferString(`{"reviewers":["octocat"]}`))
+	resp, err := l.RoundTrip(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, *sleeps)
+	assert.Equal(t, []string{`{"reviewers":["octocat"]}`, `{"reviewers":["octocat"]}`, `{"reviewers":["octocat"]}`}, bodies)
+}
+
+func TestRateLimiterGivesUpOnServerErrors(t *testing.T) {
+	attempts := 0
+	l, _ := testRateLimiter(func(req *http.Request) (*http.Response, error) {
+		attempts++
+		return response(http.StatusInternalServerError, nil), nil
+	})
+
+	req, _ := http.NewRequest("GET", "https://api.github.com/", nil)
+	resp, err := l.RoundTrip(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, maxRetries+1, attempts)
+}
+
+func TestRateLimiterRetryAfter(t *testing.T) {
+	statuses := []int{http.StatusForbidden, http.StatusOK}
+	l, sleeps := testRateLimiter(func(req *http.Request) (*http.Response, error) {
+		status := statuses[0]
+		statuses = statuses[1:]
+		if status == http.StatusForbidden {
+			return response(status, map[string]string{"Retry-After": "30"}), nil
+		}
+		return response(status, nil), nil
+	})
+
+	req, _ := http.NewRequest("GET", "https://api.github.com/", nil)
+	resp, err := l.RoundTrip(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []time.Duration{30 * time.Second}, *sleeps)
+}
+
+func TestRateLimiterUsedUp(t *testing.T) {
+	var reset time.Time
+	attempts := 0
+	l, sleeps := testRateLimiter(func(req *http.Request) (*http.Response, error) {
+		attempts++
+		return response(http.StatusForbidden, map[string]string{
+			"X-RateLimit-Remaining": "0",
+			"X-RateLimit-Reset":     strconv.FormatInt(reset.Unix(), 10),
+		}), nil
+	})
+	reset = l.now().Add(time.Hour)
+
+	t.Log("A limit that resets too far away isn't waited for")
+	req, _ := http.NewRequest("GET", "https://api.github.com/", nil)
+	resp, err := l.RoundTrip(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Equal(t, 1, attempts)
+
+	t.Log("Until it resets, requests fail without being made")
+	_, err = l.RoundTrip(req)
+	assert.EqualError(t, err, "GitHub's rate limit is used up until Fri, 01 Mar 2024 13:00:00 UTC")
+	assert.Equal(t, 1, attempts)
+	assert.Empty(t, *sleeps)
+}
@@ -0,0 +1,170 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Clever/kayvee-go/logger"
+	"github.com/google/go-github/github"
+)
+
+// OrgRouter is an AppClientIface for an app installed on several orgs. It sends each request to the
+// installation of the org that owns the repo.
+type OrgRouter struct {
+	// clients holds each org's client, by lower-cased org name
+	clients map[string]*AppClient
+}
+
+// NewOrgRouter makes a client for each of installations, which maps org names to installation IDs
+func NewOrgRouter(appID string, privateKey []byte, baseURL string, lg logger.KayveeLogger, installations map[string]string) *OrgRouter {
+	r := &OrgRouter{clients: map[string]*AppClient{}}
+	for org, installationID := range installations {
+		r.clients[strings.ToLower(org)] = &AppClient{
+			AppID:          appID,
+			InstallationID: installationID,
+			Logger:         lg,
+			PrivateKey:     privateKey,
+			BaseURL:        baseURL,
+		}
+	}
+	return r
+}
+
+// KeepTokensFresh keeps every org's access token fresh, until ctx is done
+func (r *OrgRouter) KeepTokensFresh(ctx context.Context) {
+	for _, client := range r.clients {
+		go client.KeepTokenFresh(ctx)
+	}
+}
+
+func (r *OrgRouter) client(owner string) (*AppClient, error) {
+	client, ok := r.clients[strings.ToLower(owner)]
+	if !ok {
+		return nil, fmt.Errorf("pickabot isn't installed on %s", owner)
+	}
+	return client, nil
+}
+
+// AddAssignees adds assignees to an issue
+func (r *OrgRouter) AddAssignees(ctx context.Context, owner, repo string, number int, assignees []string) (*github.Issue, *github.Response, error) {
+	client, err := r.client(owner)
+	if err != nil {
+		return &github.Issue{}, &github.Response{}, err
+	}
+	return client.AddAssignees(ctx, owner, repo, number, assignees)
+}
+
+// AddReviewers adds reviewers to a pull request
+func (r *OrgRouter) AddReviewers(ctx context.Context, owner, repo string, number int, reviewers []string) (*github.PullRequest, *github.Response, error) {
+	client, err := r.client(owner)
+	if err != nil {
+		return &github.PullRequest{}, &github.Response{}, err
+	}
+	return client.AddReviewers(ctx, owner, repo, number, reviewers)
+}
+
+// RemoveTeamReviewers removes review requests for teams, by slug, from a pull request
+func (r *OrgRouter) RemoveTeamReviewers(ctx context.Context, owner, repo string, number int, teams []string) (*github.Response, error) {
+	client, err := r.client(owner)
+	if err != nil {
+		return &github.Response{}, err
+	}
+	return client.RemoveTeamReviewers(ctx, owner, repo, number, teams)
+}
+
+// GetPullRequestStatus gets whether a pull request is open, merged or a draft
+func (r *OrgRouter) GetPullRequestStatus(ctx context.Context, owner, repo string, number int) (PullRequestStatus, error) {
+	client, err := r.client(owner)
+	if err != nil {
+		return PullRequestStatus{}, err
+	}
+	return client.GetPullRequestStatus(ctx, owner, repo, number)
+}
+
+// ListPullRequestFiles lists every file a pull request changes
+func (r *OrgRouter) ListPullRequestFiles(ctx context.Context, owner, repo string, number int) ([]*github.CommitFile, error) {
+	client, err := r.client(owner)
+	if err != nil {
+		return nil, err
+	}
+	return client.ListPullRequestFiles(ctx, owner, repo, number)
+}
+
+// GetFileContents gets the contents of the file at path in a repo at ref
+func (r *OrgRouter) GetFileContents(ctx context.Context, owner, repo, path, ref string) ([]byte, error) {
+	client, err := r.client(owner)
+	if err != nil {
+		return nil, err
+	}
+	return client.GetFileContents(ctx, owner, repo, path, ref)
+}
+
+// TokenExpiry returns the earliest time any org's access token expires, or the zero time if none have one yet
+func (r *OrgRouter) TokenExpiry() time.Time {
+	var earliest time.Time
+	for _, client := range r.clients {
+		expiry := client.TokenExpiry()
+		if !expiry.IsZero() && (earliest.IsZero() || expiry.Before(earliest)) {
+			earliest = expiry
+		}
+	}
+	return earliest
+}
+
+// installation is an entry from GitHub's list of an app's installations
+type installation struct {
+	ID      int64 `json:"id"`
+	Account struct {
+		Login string `json:"login"`
+	} `json:"account"`
+}
+
+// Installations looks up every org (or user) the app is installed on, mapped to the installation's ID.
+// It authenticates as the app, so the client doesn't need an InstallationID.
+func (a *AppClient) Installations() (map[string]string, error) {
+	a.refreshLock.Lock()
+	defer a.refreshLock.Unlock()
+	if a.jwt.IsExpired() {
+		if err := a.generateNewJWT(); err != nil {
+			return nil, fmt.Errorf("error generating JWT for GitHub access: %s", err)
+		}
+	}
+
+	installations := map[string]string{}
+	for page := 1; ; page++ {
+		req, err := http.NewRequest("GET", fmt.Sprintf("%sapp/installations?per_page=100&page=%d", a.baseURL(), page), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", a.jwt.Token))
+		req.Header.Set("Accept", "application/vnd.github.machine-man-preview+json")
+		resp, err := a.httpClient().Do(req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 400 {
+			return nil, fmt.Errorf("received failing status code: %d", resp.StatusCode)
+		}
+
+		found := []installation{}
+		if err := json.Unmarshal(body, &found); err != nil {
+			return nil, err
+		}
+		for _, i := range found {
+			installations[i.Account.Login] = fmt.Sprintf("%d", i.ID)
+		}
+		if len(found) < 100 {
+			return installations, nil
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Clever/kayvee-go/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrgRouter(t *testing.T) {
+	tokens := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/app/installations":
+			assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "Bearer "))
+			w.Write([]byte(`[{"id": 1, "account": {"login": "Clever"}}, {"id": 2, "account": {"login": "CleverLabs"}}]`))
+		case strings.HasPrefix(r.URL.Path, "/app/installations/"):
+			installationID := strings.Split(r.URL.Path, "/")[3]
+			json.NewEncoder(w).Encode(Token{Token: "token-" + installationID, Expiration: time.Now().Add(time.Hour)})
+		default:
+			tokens = append(tokens, r.Header.Get("Authorization")+" "+r.URL.Path)
+			w.Write([]byte(`{"number": 1}`))
+		}
+	}))
+	defer server.Close()
+
+	app := &AppClient{AppID: "1", Logger: logger.New("pickabot-test"), PrivateKey: testPrivateKey(t), BaseURL: server.URL}
+	installations, err := app.Installations()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"Clever": "1", "CleverLabs": "2"}, installations)
+
+	router := NewOrgRouter("1", app.PrivateKey, server.URL, app.Logger, installations)
+	assert.True(t, router.TokenExpiry().IsZero())
+
+	t.Log("Each request uses the installation of the repo's org")
+	_, _, err = router.AddReviewers(context.Background(), "CleverLabs", "repo", 1, []string{"octocat"})
+	assert.NoError(t, err)
+	_, err = router.GetPullRequestStatus(context.Background(), "clever", "repo", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"Bearer token-2 /repos/CleverLabs/repo/pulls/1/requested_reviewers",
+		"Bearer token-1 /repos/clever/repo/pulls/1",
+	}, tokens)
+	assert.False(t, router.TokenExpiry().IsZero())
+
+	t.Log("Orgs without an installation are an error")
+	_, _, err = router.AddAssignees(context.Background(), "SomeoneElse", "repo", 1, []string{"octocat"})
+	assert.EqualError(t, err, "pickabot isn't installed on SomeoneElse")
+}
@@ -7,9 +7,11 @@ package main
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
 
+	github "github.com/Clever/pickabot/github"
 	gomock "github.com/golang/mock/gomock"
-	github "github.com/google/go-github/github"
+	github0 "github.com/google/go-github/github"
 )
 
 // MockAppClientIface is a mock of AppClientIface interface.
@@ -36,11 +38,11 @@ func (m *MockAppClientIface) EXPECT() *MockAppClientIfaceMockRecorder {
 }
 
 // AddAssignees mocks base method.
-func (m *MockAppClientIface) AddAssignees(ctx context.Context, owner, repo string, number int, assignees []string) (*github.Issue, *github.Response, error) {
+func (m *MockAppClientIface) AddAssignees(ctx context.Context, owner, repo string, number int, assignees []string) (*github0.Issue, *github0.Response, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "AddAssignees", ctx, owner, repo, number, assignees)
-	ret0, _ := ret[0].(*github.Issue)
-	ret1, _ := ret[1].(*github.Response)
+	ret0, _ := ret[0].(*github0.Issue)
+	ret1, _ := ret[1].(*github0.Response)
 	ret2, _ := ret[2].(error)
 	return ret0, ret1, ret2
 }
@@ -52,11 +54,11 @@ func (mr *MockAppClientIfaceMockRecorder) AddAssignees(ctx, owner, repo, number,
 }
 
 // AddReviewers mocks base method.
-func (m *MockAppClientIface) AddReviewers(ctx context.Context, owner, repo string, number int, reviewers []string) (*github.PullRequest, *github.Response, error) {
+func (m *MockAppClientIface) AddReviewers(ctx context.Context, owner, repo string, number int, reviewers []string) (*github0.PullRequest, *github0.Response, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "AddReviewers", ctx, owner, repo, number, reviewers)
-	ret0, _ := ret[0].(*github.PullRequest)
-	ret1, _ := ret[1].(*github.Response)
+	ret0, _ := ret[0].(*github0.PullRequest)
+	ret1, _ := ret[1].(*github0.Response)
 	ret2, _ := ret[2].(error)
 	return ret0, ret1, ret2
 }
@@ -66,3 +68,77 @@ func (mr *MockAppClientIfaceMockRecorder) AddReviewers(ctx, owner, repo, number,
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddReviewers", reflect.TypeOf((*MockAppClientIface)(nil).AddReviewers), ctx, owner, repo, number, reviewers)
 }
+
+// GetFileContents mocks base method.
+func (m *MockAppClientIface) GetFileContents(ctx context.Context, owner, repo, path, ref string) ([]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetFileContents", ctx, owner, repo, path, ref)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetFileContents indicates an expected call of GetFileContents.
+func (mr *MockAppClientIfaceMockRecorder) GetFileContents(ctx, owner, repo, path, ref interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetFileContents", reflect.TypeOf((*MockAppClientIface)(nil).GetFileContents), ctx, owner, repo, path, ref)
+}
+
+// GetPullRequestStatus mocks base method.
+func (m *MockAppClientIface) GetPullRequestStatus(ctx context.Context, owner, repo string, number int) (github.PullRequestStatus, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPullRequestStatus", ctx, owner, repo, number)
+	ret0, _ := ret[0].(github.PullRequestStatus)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPullRequestStatus indicates an expected call of GetPullRequestStatus.
+func (mr *MockAppClientIfaceMockRecorder) GetPullRequestStatus(ctx, owner, repo, number interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPullRequestStatus", reflect.TypeOf((*MockAppClientIface)(nil).GetPullRequestStatus), ctx, owner, repo, number)
+}
+
+// ListPullRequestFiles mocks base method.
+func (m *MockAppClientIface) ListPullRequestFiles(ctx context.Context, owner, repo string, number int) ([]*github0.CommitFile, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListPullRequestFiles", ctx, owner, repo, number)
+	ret0, _ := ret[0].([]*github0.CommitFile)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListPullRequestFiles indicates an expected call of ListPullRequestFiles.
+func (mr *MockAppClientIfaceMockRecorder) ListPullRequestFiles(ctx, owner, repo, number interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListPullRequestFiles", reflect.TypeOf((*MockAppClientIface)(nil).ListPullRequestFiles), ctx, owner, repo, number)
+}
+
+// RemoveTeamReviewers mocks base method.
+func (m *MockAppClientIface) RemoveTeamReviewers(ctx context.Context, owner, repo string, number int, teams []string) (*github0.Response, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RemoveTeamReviewers", ctx, owner, repo, number, teams)
+	ret0, _ := ret[0].(*github0.Response)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RemoveTeamReviewers indicates an expected call of RemoveTeamReviewers.
+func (mr *MockAppClientIfaceMockRecorder) RemoveTeamReviewers(ctx, owner, repo, number, teams interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveTeamReviewers", reflect.TypeOf((*MockAppClientIface)(nil).RemoveTeamReviewers), ctx, owner, repo, number, teams)
+}
+
+// TokenExpiry mocks base method.
+func (m *MockAppClientIface) TokenExpiry() time.Time {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TokenExpiry")
+	ret0, _ := ret[0].(time.Time)
+	return ret0
+}
+
+// TokenExpiry indicates an expected call of TokenExpiry.
+func (mr *MockAppClientIfaceMockRecorder) TokenExpiry() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TokenExpiry", reflect.TypeOf((*MockAppClientIface)(nil).TokenExpiry))
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"math/rand"
+	"net/http"
 	"os"
+	"runtime/debug"
 	"strings"
 	"time"
 
@@ -21,6 +24,9 @@ import (
 
 var lg = logger.New("pickabot")
 
+// version is set at build time, e.g. with -ldflags "-X main.version=v1.2.3"
+var version = "dev"
+
 // SlackLoop is the endless service loop pickabot remains in after startup --
 // e.g. the steady-state of the bot.
 func SlackLoop(s *Bot) {
@@ -38,11 +44,17 @@ func SlackLoop(s *Bot) {
 		for evt := range client.Events {
 			switch evt.Type {
 			case socketmode.EventTypeConnecting:
+				s.setSlackConnection("connecting")
 				s.Logger.InfoD("connecting", logger.M{"message": "Connecting to Slack with Socket Mode..."})
 			case socketmode.EventTypeConnectionError:
+				s.setSlackConnection("connection failed, retrying")
 				s.Logger.InfoD("connection-failed", logger.M{"message": "Connection failed. Retrying later..."})
 			case socketmode.EventTypeConnected:
+				s.setSlackConnection("connected")
 				s.Logger.InfoD("connection-succeeded", logger.M{"message": "Connected to Slack with Socket Mode."})
+			case socketmode.EventTypeDisconnect:
+				s.setSlackConnection("disconnected")
+				s.Logger.InfoD("disconnected", logger.M{"message": "Disconnected from Slack, reconnecting..."})
 			case socketmode.EventTypeEventsAPI:
 				eventsAPIEvent, ok := evt.Data.(slackevents.EventsAPIEvent)
 				if !ok {
@@ -69,29 +81,53 @@ func SlackLoop(s *Bot) {
 						s.DecodeMessage(messageEvent)
 					}
 				}
+			case socketmode.EventTypeInteractive:
+				callback, ok := evt.Data.(slack.InteractionCallback)
+				if !ok {
+					s.Logger.ErrorD("type-error", logger.M{"message": "Could not cast to interaction callback"})
+					continue
+				}
+				client.Ack(*evt.Request)
+
+				if callback.Type != slack.InteractionTypeBlockActions {
+					continue
+				}
+				for _, action := range callback.ActionCallback.BlockActions {
+					if IsTeamChoiceAction(action) {
+						s.HandleTeamChoice(callback.User.ID, callback.Channel.ID, action.Value)
+					}
+				}
 			}
 		}
 	}()
 
 	// Cache refresh loop
-	go func() {
-		for {
-			time.Sleep(60 * time.Minute)
-			teams, overrides, userFlair, err := buildTeams(s.WhoIsWhoClient)
-			if err != nil {
-				s.Logger.CriticalD("user cache refresh failed", logger.M{"error": err})
-				continue
-			}
-			s.TeamToTeamMembers = teams
-			s.TeamOverrides = overrides
-			s.UserFlair = userFlair
-			s.LastCacheRefresh = time.Now()
-		}
-	}()
+	go s.refreshLoop()
+
+	// Health checks, and GitHub webhooks if they're configured
+	go serveHTTP(s)
 
 	client.Run()
 }
 
+// serveHTTP listens on WEBHOOK_PORT, default 8080, for health checks at /_health, and for GitHub webhooks
+// if GITHUB_WEBHOOK_SECRET is set
+func serveHTTP(s *Bot) {
+	port := os.Getenv("WEBHOOK_PORT")
+	if port == "" {
+		port = "8080"
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	if len(s.WebhookSecret) > 0 {
+		mux.HandleFunc("/github/webhook", s.HandleGithubWebhook)
+	}
+	s.Logger.InfoD("http-listening", logger.M{"port": port, "webhooks": len(s.WebhookSecret) > 0})
+	log.Fatal(http.ListenAndServe(":"+port, mux))
+}
+
 func requireEnvVar(s string) string {
 	val := os.Getenv(s)
 	if val == "" {
@@ -100,6 +136,50 @@ func requireEnvVar(s string) string {
 	return val
 }
 
+// splitEnvVar returns the comma-separated values of an optional env var
+func splitEnvVar(s string) []string {
+	return splitList(os.Getenv(s))
+}
+
+// splitList returns the comma-separated values in s
+func splitList(s string) []string {
+	values := []string{}
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// durationEnvVar parses an optional env var such as "30m", returning zero if it isn't set
+func durationEnvVar(s string) time.Duration {
+	val := os.Getenv(s)
+	if val == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil || d <= 0 {
+		log.Fatalf("env var %s must be a positive duration, e.g. 30m", s)
+	}
+	return d
+}
+
+// buildVersion is version, with the commit it was built from if the build recorded one
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return version
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" && len(setting.Value) >= 7 {
+			return fmt.Sprintf("%s (%s)", version, setting.Value[:7])
+		}
+	}
+	return version
+}
+
 func main() {
 
 	api := slack.New(
@@ -109,51 +189,90 @@ func main() {
 		slack.OptionDebug(false),
 	)
 
-	endpoint, err := discovery.URL("who-is-who", "default")
-	if err != nil {
-		log.Fatalf("who-is-who discovery error: %s", err)
+	// Users and teams come from DIRECTORY_FILE if it's set, and from who-is-who otherwise
+	var directory directoryProvider
+	var err error
+	if directoryFile := os.Getenv("DIRECTORY_FILE"); directoryFile != "" {
+		directory, err = newStaticDirectory(directoryFile)
+		if err != nil {
+			log.Fatalf("error loading directory file: %s", err)
+		}
+	} else {
+		directory, err = whoIsWhoDirectoryFromEnv()
+		if err != nil {
+			log.Fatalf("error setting up who-is-who: %s", err)
+		}
 	}
-	client := whoswho.NewClient(endpoint)
 
-	teams, overrides, userFlair, err := buildTeams(client) // populate a cached set of teams and their members
+	// pickabot's own state, e.g. team aliases, is kept in STATE_FILE if it's set
+	var store stateStore = &memoryStateStore{}
+	if stateFile := os.Getenv("STATE_FILE"); stateFile != "" {
+		store = &fileStateStore{Path: stateFile}
+	} else {
+		lg.Warn("STATE_FILE is not set, so aliases, overrides and flair changes will be lost on restart")
+	}
+	state, err := store.Load()
 	if err != nil {
-		log.Fatalf("error building teams: %s", err)
+		log.Fatalf("error loading state: %s", err)
 	}
 
 	appID := requireEnvVar("GITHUB_APP_ID")
-	installationID := requireEnvVar("GITHUB_INSTALLATION_ID")
 	devMode := requireEnvVar("DEV_MODE") != "false"
-	githubOrg := requireEnvVar("GITHUB_ORG_NAME")
+	// GITHUB_ORG_NAME is one org, or several separated by commas
+	githubOrgs := splitList(requireEnvVar("GITHUB_ORG_NAME"))
 	githubPrivateKey := requireEnvVar("GITHUB_PRIVATE_KEY")
 	privateKeyBytes := []byte(githubPrivateKey)
 
-	githubClient := &github.AppClient{
-		AppID:          appID,
-		InstallationID: installationID,
-		Logger:         lg,
-		PrivateKey:     privateKeyBytes,
+	// GITHUB_BASE_URL points pickabot at GitHub Enterprise Server, e.g. https://github.example.com/api/v3/
+	githubBaseURL := os.Getenv("GITHUB_BASE_URL")
+	githubWebHost, err := github.WebHost(githubBaseURL)
+	if err != nil {
+		log.Fatalf("env var GITHUB_BASE_URL is invalid: %s", err)
 	}
+
+	app := &github.AppClient{AppID: appID, Logger: lg, PrivateKey: privateKeyBytes, BaseURL: githubBaseURL}
+	installations, err := githubInstallations(githubOrgs, app)
 	if err != nil {
-		log.Fatalf("error setting up github client: %s", err)
+		log.Fatalf("error finding GitHub app installations: %s", err)
 	}
+	githubClient := github.NewOrgRouter(appID, privateKeyBytes, githubBaseURL, lg, installations)
+	githubClient.KeepTokensFresh(context.Background())
 
 	pickabot := &Bot{
-		DevMode:           devMode,
-		GithubClient:      githubClient,
-		GithubOrgName:     githubOrg,
-		SlackAPIService:   &slackapi.SlackAPIServer{Api: api},
-		Logger:            lg,
-		Name:              requireEnvVar("BOT_NAME"),
-		RandomSource:      rand.NewSource(time.Now().UnixNano()),
-		UserFlair:         userFlair,
-		TeamOverrides:     overrides,
-		TeamToTeamMembers: teams,
-		WhoIsWhoClient:    client,
-		LastCacheRefresh:  time.Now(),
+		DevMode:             devMode,
+		GithubClient:        githubClient,
+		GithubOrgNames:      githubOrgs,
+		GithubWebHost:       githubWebHost,
+		SlackAPIService:     &slackapi.SlackAPIServer{Api: api},
+		Logger:              lg,
+		Name:                requireEnvVar("BOT_NAME"),
+		RandomSource:        &lockedSource{src: rand.NewSource(time.Now().UnixNano())},
+		Directory:           directory,
+		SyncDirectory:       os.Getenv("DIRECTORY_SYNC") != "false",
+		SnapshotPath:        os.Getenv("CACHE_SNAPSHOT_FILE"),
+		RefreshInterval:     durationEnvVar("CACHE_REFRESH_INTERVAL"),
+		StaleAfter:          durationEnvVar("CACHE_STALE_AFTER"),
+		AdminSlackIDs:       splitEnvVar("PICKABOT_ADMINS"),
+		NotificationChannel: os.Getenv("NOTIFICATION_CHANNEL"),
+		WebhookSecret:       []byte(os.Getenv("GITHUB_WEBHOOK_SECRET")),
+		WebhookChannel:      os.Getenv("WEBHOOK_CHANNEL"),
+		State:               state,
+		StateStore:          store,
+		StartTime:           time.Now(),
+		Version:             buildVersion(),
+	}
+
+	// populate a cached set of teams and their members, falling back to the last snapshot if the directory is down.
+	// overrides and flair are imported from the directory the first time, and kept in the state after that
+	if _, err := pickabot.refreshTeams(); err != nil {
+		if snapshotErr := pickabot.restoreSnapshot(); snapshotErr != nil {
+			log.Fatalf("error building teams: %s (and no snapshot to fall back on: %s)", err, snapshotErr)
+		}
+		lg.WarnD("started-from-snapshot", logger.M{"error": err.Error(), "last-refresh": pickabot.cache().LastRefresh})
 	}
 
 	// The below code is just prints out the teams and their members for debugging purposes and as a sanity check
-	for teamName := range teams {
+	for teamName := range pickabot.cache().TeamToTeamMembers {
 		fmt.Printf("team=%s has members: ", teamName)
 		users := pickabot.buildTeam(teamName)
 		userText := []string{}
@@ -166,14 +285,67 @@ func main() {
 	SlackLoop(pickabot)
 }
 
-// This method uses the who-is-who go client to populate the set of teams and their members
-func buildTeams(client whoIsWhoClientIface) (map[string][]whoswho.User, []Override, map[string]string, error) {
-	users, err := client.GetUserList()
+// githubInstallations maps each of orgs to the ID of the app's installation on it. GITHUB_INSTALLATION_ID is
+// the ID for a single org, or "org:id" pairs, e.g. "Clever:123,CleverLabs:456". The installations of orgs
+// it doesn't mention are looked up through app.
+func githubInstallations(orgs []string, app *github.AppClient) (map[string]string, error) {
+	installations := map[string]string{}
+	for _, pair := range splitEnvVar("GITHUB_INSTALLATION_ID") {
+		if org, id, ok := strings.Cut(pair, ":"); ok {
+			installations[strings.ToLower(strings.TrimSpace(org))] = strings.TrimSpace(id)
+		} else if len(orgs) == 1 {
+			installations[strings.ToLower(orgs[0])] = pair
+		} else {
+			return nil, fmt.Errorf("GITHUB_INSTALLATION_ID must be org:id pairs when there are several orgs")
+		}
+	}
+
+	var found map[string]string
+	for _, org := range orgs {
+		if _, ok := installations[strings.ToLower(org)]; ok {
+			continue
+		}
+		if found == nil {
+			var err error
+			if found, err = app.Installations(); err != nil {
+				return nil, err
+			}
+		}
+		for account, id := range found {
+			if strings.EqualFold(account, org) {
+				installations[strings.ToLower(org)] = id
+			}
+		}
+		if _, ok := installations[strings.ToLower(org)]; !ok {
+			return nil, fmt.Errorf("the GitHub app isn't installed on %s", org)
+		}
+	}
+	return installations, nil
+}
+
+// whoIsWhoDirectoryFromEnv finds who-is-who using discovery, and maps its teams to pickabot teams
+// using the rules in TEAM_MAPPING_FILE, if it's set
+func whoIsWhoDirectoryFromEnv() (*whoIsWhoDirectory, error) {
+	endpoint, err := discovery.URL("who-is-who", "default")
 	if err != nil {
-		return nil, []Override{}, map[string]string{}, err
+		return nil, fmt.Errorf("who-is-who discovery error: %s", err)
 	}
+	client := whoswho.NewClient(endpoint)
 
-	// fetch users from who-is-who
+	var teamMappingRules []teamMappingRule
+	if mappingFile := os.Getenv("TEAM_MAPPING_FILE"); mappingFile != "" {
+		teamMappingRules, err = loadTeamMappingRules(mappingFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading team mapping rules: %s", err)
+		}
+	}
+
+	return &whoIsWhoDirectory{Client: client, Logger: lg, Rules: teamMappingRules}, nil
+}
+
+// This method populates the set of teams and their members from who-is-who's users.
+// rules decide which team each user is on, and default to defaultTeamMappingRules.
+func buildTeams(users []whoswho.User, rules []teamMappingRule) (map[string][]whoswho.User, []Override, map[string]string) {
 	overrides := []Override{}
 	teams := map[string][]whoswho.User{}
 	userFlair := map[string]string{}
@@ -184,11 +356,15 @@ func buildTeams(client whoIsWhoClientIface) (map[string][]whoswho.User, []Overri
 
 		// Add overrides from who-is-who
 		for _, to := range u.Pickabot.TeamOverrides {
-			overrides = append(overrides, Override{
+			override := Override{
 				User:    u,
 				Team:    to.Team,
 				Include: to.Include,
-			})
+			}
+			if to.Until > 0 {
+				override.Until = time.Unix(to.Until, 0)
+			}
+			overrides = append(overrides, override)
 		}
 
 		// Add flair
@@ -196,18 +372,15 @@ func buildTeams(client whoIsWhoClientIface) (map[string][]whoswho.User, []Overri
 			userFlair[u.SlackID] = u.Pickabot.Flair
 		}
 
-		if !(strings.HasPrefix(u.Team, "Engineering - ") && u.Active) {
+		// Format the team name
+		team, ok := mapTeam(rules, u.Team)
+		if !ok {
 			continue
 		}
-		// Format the team name
-		split := strings.Split(u.Team, " - ")
-		team := split[1]
-		team = strings.ToLower(team)
-		team = strings.ReplaceAll(team, " ", "-")
 
 		// Write user to teams
 		teams[team] = append(teams[team], u)
 	}
 
-	return teams, overrides, userFlair, nil
+	return teams, overrides, userFlair
 }
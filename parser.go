@@ -2,40 +2,160 @@ package main
 
 import (
 	"fmt"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
-
-	"mvdan.cc/xurls"
+	"unicode"
 )
 
 type githubPR struct {
 	Owner    string
 	Repo     string
 	PRNumber int
+	// Issue is set when the pull request was given as an issue URL, so it may turn out to be an issue
+	Issue bool
 }
 
-// parseMessageForPRs searchs for strings matching: github.com/{ORG_NAME}/{REPO}/pull/...
-func parseMessageForPRs(githubOrg, message string) []githubPR {
-	var prs []githubPR
-	githubURLMatcher, _ := xurls.StrictMatchingScheme(fmt.Sprintf("github.com/%s", githubOrg))
-	urls := githubURLMatcher.FindAllString(message, -1)
+var (
+	// slackLinkRegex matches Slack's formatting of a link with a label, <url|label>
+	slackLinkRegex = regexp.MustCompile(`<([^<>|]+)\|[^<>]*>`)
+	// prShorthandRegex matches repo#123 and org/repo#123, when not part of a longer word or URL
+	prShorthandRegex = regexp.MustCompile(`(?:^|[\s(,;])(?:([\w.-]+)/)?([\w.-]+)#(\d+)\b`)
+	// prListStartRegex matches the "for" that starts a list of pull requests, e.g. "assign infra for pickabot#1"
+	prListStartRegex = regexp.MustCompile(`(?i)\bfor\s+`)
+	// prTokenRegex matches a whole repo#123 or org/repo#123
+	prTokenRegex = regexp.MustCompile(`^(?:[\w.-]+/)?[\w.-]+#\d+$`)
+)
 
-	for _, url := range urls {
-		urlParts := strings.Split(url, "/")
-		// length checks and action check
-		if len(urlParts) < 5 || urlParts[3] != "pull" {
-			continue
-		}
-		prNumber, err := strconv.Atoi(urlParts[4])
+// prURLRegex matches pull request and issue URLs on githubHost, including ones for a pull request's files
+// or commits tab, e.g. github.com/Clever/pickabot/pull/1/files
+func prURLRegex(githubHost string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)(?:https?://)?(?:www\.)?` + regexp.QuoteMeta(githubHost) +
+		`/([\w.-]+)/([\w.-]+)/(pull|pulls|issues)/(\d+)\b`)
+}
+
+// parseMessageForPRs finds the pull requests in message that belong to one of githubOrgs. It recognizes:
+//
+//   - URLs on githubHost, e.g. https://github.com/Clever/pickabot/pull/1, with or without a /files or
+//     /commits tab, in Slack's <url|label> link format, and issue URLs, .../issues/1
+//   - org/repo#1, e.g. Clever/pickabot#1
+//   - repo#1, for a repo in the first of githubOrgs, only in the list of pull requests after "for", so
+//     words like "step#2" elsewhere aren't taken for pull requests
+//
+// Each pull request is returned once, in the order it was first mentioned. Pull requests that were
+// mentioned again are returned as duplicates.
+func parseMessageForPRs(githubHost string, githubOrgs []string, message string) ([]githubPR, []githubPR) {
+	if len(githubOrgs) == 0 {
+		return nil, nil
+	}
+	message = slackLinkRegex.ReplaceAllString(message, "$1")
+
+	type match struct {
+		pos int
+		pr  githubPR
+	}
+	matches := []match{}
+	add := func(pos int, org, repo, number string, issue bool) {
+		prNumber, err := strconv.Atoi(number)
 		if err != nil {
+			return
+		}
+		for _, o := range githubOrgs {
+			if strings.EqualFold(o, org) {
+				matches = append(matches, match{pos: pos, pr: githubPR{Owner: org, Repo: repo, PRNumber: prNumber, Issue: issue}})
+				return
+			}
+		}
+	}
+
+	for _, m := range prURLRegex(githubHost).FindAllStringSubmatchIndex(message, -1) {
+		add(m[0], message[m[2]:m[3]], message[m[4]:m[5]], message[m[8]:m[9]], strings.EqualFold(message[m[6]:m[7]], "issues"))
+	}
+	listStart, listEnd := prListBounds(githubHost, message)
+	for _, m := range prShorthandRegex.FindAllStringSubmatchIndex(message, -1) {
+		org := githubOrgs[0]
+		if m[2] >= 0 {
+			org = message[m[2]:m[3]]
+		} else if m[4] < listStart || m[5] > listEnd {
 			continue
 		}
-		prs = append(prs, githubPR{
-			Owner:    urlParts[1],
-			Repo:     urlParts[2],
-			PRNumber: prNumber,
-		})
+		add(m[0], org, message[m[4]:m[5]], message[m[6]:m[7]], false)
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].pos < matches[j].pos })
+
+	var prs, duplicates []githubPR
+	for _, m := range matches {
+		if !containsPR(prs, m.pr) {
+			prs = append(prs, m.pr)
+		} else if !containsPR(duplicates, m.pr) {
+			duplicates = append(duplicates, m.pr)
+		}
 	}
+	return prs, duplicates
+}
 
-	return prs
+// prListBounds finds the list of pull requests after the first "for" in message, returning where it starts and
+// ends. The list is made of pull request URLs and references, separated by spaces, commas and "and", and ends at
+// the first word that's none of those. Both are -1 if there's no "for".
+func prListBounds(githubHost, message string) (int, int) {
+	loc := prListStartRegex.FindStringIndex(message)
+	if loc == nil {
+		return -1, -1
+	}
+	urlRegex := prURLRegex(githubHost)
+	end := loc[1]
+	for end < len(message) {
+		// skip separators, then take the next word
+		next := strings.IndexFunc(message[end:], func(r rune) bool { return !unicode.IsSpace(r) && r != ',' })
+		if next < 0 {
+			break
+		}
+		wordStart := end + next
+		wordEnd := strings.IndexFunc(message[wordStart:], func(r rune) bool { return unicode.IsSpace(r) || r == ',' })
+		if wordEnd < 0 {
+			wordEnd = len(message)
+		} else {
+			wordEnd += wordStart
+		}
+		word := strings.Trim(message[wordStart:wordEnd], "<>().;")
+		if !strings.EqualFold(word, "and") && word != "&" && !prTokenRegex.MatchString(word) && !urlRegex.MatchString(word) {
+			break
+		}
+		end = wordEnd
+	}
+	return loc[1], end
+}
+
+// containsPR reports whether prs contains pr. GitHub names aren't case sensitive.
+func containsPR(prs []githubPR, pr githubPR) bool {
+	for _, p := range prs {
+		if strings.EqualFold(p.Owner, pr.Owner) && strings.EqualFold(p.Repo, pr.Repo) && p.PRNumber == pr.PRNumber {
+			return true
+		}
+	}
+	return false
+}
+
+// duplicatePRsNote tells the user which pull requests were mentioned more than once in message, and so
+// only assigned once. It's empty if there were none.
+func (bot *Bot) duplicatePRsNote(message string) string {
+	_, duplicates := parseMessageForPRs(bot.githubHost(), bot.GithubOrgNames, message)
+	if len(duplicates) == 0 {
+		return ""
+	}
+	urls := []string{}
+	for _, pr := range duplicates {
+		urls = append(urls, pullRequestURL(bot.githubHost(), pr))
+	}
+	return fmt.Sprintf("%s was mentioned more than once, so I only assigned it once", strings.Join(urls, ", "))
+}
+
+// pullRequestURL links to pr on the GitHub website at githubHost, as an issue if it was given as one
+func pullRequestURL(githubHost string, pr githubPR) string {
+	kind := "pull"
+	if pr.Issue {
+		kind = "issues"
+	}
+	return fmt.Sprintf("https://%s/%s/%s/%s/%d", githubHost, pr.Owner, pr.Repo, kind, pr.PRNumber)
 }
@@ -11,15 +11,166 @@ func TestParseMessageForPRs(t *testing.T) {
 
 	t.Log("Validates Github and org name")
 	message := "pick a team for http://google.com http://yahoo.com http://github.com/msn"
-	prs := parseMessageForPRs("pack", message)
+	prs, _ := parseMessageForPRs("github.com", []string{"pack"}, message)
 	assert.Equal(0, len(prs))
 
 	t.Log("Filters out non-prs")
 	message = `pick a team member for 
 		https://github.com/test/repo1/pull/1 https://github.com/test/repo2/pull/2/files
 		https://github.com/test/not-a-pr`
-	prs = parseMessageForPRs("test", message)
+	prs, _ = parseMessageForPRs("github.com", []string{"test"}, message)
 	assert.Equal(2, len(prs))
 	assert.Contains(prs, githubPR{Owner: "test", Repo: "repo1", PRNumber: 1})
 	assert.Contains(prs, githubPR{Owner: "test", Repo: "repo2", PRNumber: 2})
+
+	t.Log("Only matches the given host")
+	message = "pick a team for https://github.example.com/test/repo1/pull/1 https://github.com/test/repo2/pull/2"
+	prs, _ = parseMessageForPRs("github.example.com", []string{"test"}, message)
+	assert.Equal([]githubPR{{Owner: "test", Repo: "repo1", PRNumber: 1}}, prs)
+	prs, _ = parseMessageForPRs("127.0.0.1:8080", []string{"test"}, "https://127.0.0.1:8080/test/repo1/pull/3")
+	assert.Equal([]githubPR{{Owner: "test", Repo: "repo1", PRNumber: 3}}, prs)
+
+	t.Log("Matches any of several orgs, but not orgs that start with one of them")
+	message = "https://github.com/test/repo1/pull/1 https://github.com/other/repo2/pull/2 https://github.com/testing/repo3/pull/3"
+	prs, _ = parseMessageForPRs("github.com", []string{"test", "other"}, message)
+	assert.Equal([]githubPR{{Owner: "test", Repo: "repo1", PRNumber: 1}, {Owner: "other", Repo: "repo2", PRNumber: 2}}, prs)
+	prs, _ = parseMessageForPRs("github.com", nil, message)
+	assert.Empty(prs)
+}
+
+func TestParseMessageForPRForms(t *testing.T) {
+	orgs := []string{"Clever", "other"}
+	for _, test := range []struct {
+		name               string
+		message            string
+		expectedPRs        []githubPR
+		expectedDuplicates []githubPR
+	}{
+		{
+			name:        "pull request URL",
+			message:     "assign infra for https://github.com/Clever/pickabot/pull/12",
+			expectedPRs: []githubPR{{Owner: "Clever", Repo: "pickabot", PRNumber: 12}},
+		},
+		{
+			name:        "URL without a scheme",
+			message:     "assign infra for github.com/Clever/pickabot/pull/12",
+			expectedPRs: []githubPR{{Owner: "Clever", Repo: "pickabot", PRNumber: 12}},
+		},
+		{
+			name:        "files tab",
+			message:     "assign infra for https://github.com/Clever/pickabot/pull/12/files",
+			expectedPRs: []githubPR{{Owner: "Clever", Repo: "pickabot", PRNumber: 12}},
+		},
+		{
+			name:        "commits tab",
+			message:     "assign infra for https://github.com/Clever/pickabot/pull/12/commits/abc123",
+			expectedPRs: []githubPR{{Owner: "Clever", Repo: "pickabot", PRNumber: 12}},
+		},
+		{
+			name:        "URL with a comment anchor",
+			message:     "assign infra for https://github.com/Clever/pickabot/pull/12#issuecomment-345",
+			expectedPRs: []githubPR{{Owner: "Clever", Repo: "pickabot", PRNumber: 12}},
+		},
+		{
+			name:        "issue URL",
+			message:     "assign infra for https://github.com/Clever/pickabot/issues/7",
+			expectedPRs: []githubPR{{Owner: "Clever", Repo: "pickabot", PRNumber: 7, Issue: true}},
+		},
+		{
+			name:        "Slack link",
+			message:     "assign infra for <https://github.com/Clever/pickabot/pull/12>",
+			expectedPRs: []githubPR{{Owner: "Clever", Repo: "pickabot", PRNumber: 12}},
+		},
+		{
+			name:        "Slack link with a label",
+			message:     "assign infra for <https://github.com/Clever/pickabot/pull/12|the fix>",
+			expectedPRs: []githubPR{{Owner: "Clever", Repo: "pickabot", PRNumber: 12}},
+		},
+		{
+			name:        "Slack link labeled with its URL",
+			message:     "assign infra for <https://github.com/Clever/pickabot/pull/12|https://github.com/Clever/pickabot/pull/12>",
+			expectedPRs: []githubPR{{Owner: "Clever", Repo: "pickabot", PRNumber: 12}},
+		},
+		{
+			name:        "repo#number is in the first org",
+			message:     "assign infra for pickabot#12",
+			expectedPRs: []githubPR{{Owner: "Clever", Repo: "pickabot", PRNumber: 12}},
+		},
+		{
+			name:        "org/repo#number",
+			message:     "assign infra for other/tools#3",
+			expectedPRs: []githubPR{{Owner: "other", Repo: "tools", PRNumber: 3}},
+		},
+		{
+			name:    "org/repo#number for another org",
+			message: "assign infra for someone/tools#3",
+		},
+		{
+			name:    "number without a repo",
+			message: "assign infra for #12",
+		},
+		{
+			name:    "Slack channel link",
+			message: "assign infra in <#C123|general>",
+		},
+		{
+			name:    "URL for another org",
+			message: "assign infra for https://github.com/someone/pickabot/pull/12",
+		},
+		{
+			name:    "repo URL",
+			message: "assign infra for https://github.com/Clever/pickabot",
+		},
+		{
+			name:    "repo#number in other words",
+			message: "PR#5 is next, so assign infra, then step#2 and item#3",
+		},
+		{
+			name:        "repo#number after the list after for",
+			message:     "assign infra for pickabot#12 and then item#3",
+			expectedPRs: []githubPR{{Owner: "Clever", Repo: "pickabot", PRNumber: 12}},
+		},
+		{
+			name:        "org/repo#number anywhere",
+			message:     "assign infra, it's Clever/pickabot#12",
+			expectedPRs: []githubPR{{Owner: "Clever", Repo: "pickabot", PRNumber: 12}},
+		},
+		{
+			name:    "repo#number without a for",
+			message: "assign infra to pickabot#12",
+		},
+		{
+			name:    "number that's too big",
+			message: "assign infra for pickabot#99999999999999999999",
+		},
+		{
+			name:    "mixed forms, in order",
+			message: "assign infra for tools#3, <https://github.com/other/web/pull/5|web> and Clever/pickabot#1",
+			expectedPRs: []githubPR{
+				{Owner: "Clever", Repo: "tools", PRNumber: 3},
+				{Owner: "other", Repo: "web", PRNumber: 5},
+				{Owner: "Clever", Repo: "pickabot", PRNumber: 1},
+			},
+		},
+		{
+			name:               "the same pull request in different forms",
+			message:            "assign infra for https://github.com/Clever/pickabot/pull/12 pickabot#12 clever/Pickabot#12 https://github.com/Clever/pickabot/pull/12/files",
+			expectedPRs:        []githubPR{{Owner: "Clever", Repo: "pickabot", PRNumber: 12}},
+			expectedDuplicates: []githubPR{{Owner: "Clever", Repo: "pickabot", PRNumber: 12}},
+		},
+		{
+			name:    "same number in different repos",
+			message: "assign infra for pickabot#12 tools#12",
+			expectedPRs: []githubPR{
+				{Owner: "Clever", Repo: "pickabot", PRNumber: 12},
+				{Owner: "Clever", Repo: "tools", PRNumber: 12},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			prs, duplicates := parseMessageForPRs("github.com", orgs, test.message)
+			assert.Equal(t, test.expectedPRs, prs)
+			assert.Equal(t, test.expectedDuplicates, duplicates)
+		})
+	}
 }
@@ -3,6 +3,8 @@ package main
 import (
 	"errors"
 	"math/rand"
+	"sync"
+	"time"
 
 	whoswho "github.com/Clever/who-is-who/go-client"
 )
@@ -48,3 +50,78 @@ func pickUser(users []whoswho.User, omit *whoswho.User, source rand.Source) (who
 	choice := rand.New(source).Intn(len(dedupedUsers))
 	return dedupedUsers[choice], nil
 }
+
+const (
+	// strategyRandom picks any eligible user
+	strategyRandom = "random"
+	// strategyLeastRecent picks among the users who were picked longest ago, or never
+	strategyLeastRecent = "least-recent"
+)
+
+// pickUsers chooses up to n distinct Users from the list of users using strategy.
+// lastPicked holds when each user (by SlackID) was last picked, for strategyLeastRecent.
+// if omit is non-nil, it will omit that user from any response
+func pickUsers(users []whoswho.User, omit *whoswho.User, n int, strategy string, lastPicked map[string]time.Time, source rand.Source) ([]whoswho.User, error) {
+	remaining := users
+	picked := []whoswho.User{}
+	for len(picked) < n {
+		candidates := remaining
+		if strategy == strategyLeastRecent {
+			candidates = leastRecentlyPicked(remaining, omit, lastPicked)
+		}
+
+		user, err := pickUser(candidates, omit, source)
+		if err == ErrNoUsers && len(picked) > 0 {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		picked = append(picked, user)
+
+		next := []whoswho.User{}
+		for _, u := range remaining {
+			if u.SlackID != user.SlackID {
+				next = append(next, u)
+			}
+		}
+		remaining = next
+	}
+	return picked, nil
+}
+
+// leastRecentlyPicked returns the users, other than omit, who were picked longest ago
+func leastRecentlyPicked(users []whoswho.User, omit *whoswho.User, lastPicked map[string]time.Time) []whoswho.User {
+	oldest := []whoswho.User{}
+	var oldestTime time.Time
+	for _, u := range users {
+		if omit != nil && u.SlackID == omit.SlackID {
+			continue
+		}
+		t := lastPicked[u.SlackID]
+		if len(oldest) == 0 || t.Before(oldestTime) {
+			oldest = []whoswho.User{u}
+			oldestTime = t
+		} else if t.Equal(oldestTime) {
+			oldest = append(oldest, u)
+		}
+	}
+	return oldest
+}
+
+// lockedSource is a rand.Source that's safe to use from several goroutines
+type lockedSource struct {
+	lock sync.Mutex
+	src  rand.Source
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.src.Seed(seed)
+}
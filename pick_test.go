@@ -3,6 +3,7 @@ package main
 import (
 	"math/rand"
 	"testing"
+	"time"
 
 	whoswho "github.com/Clever/who-is-who/go-client"
 	"github.com/stretchr/testify/assert"
@@ -60,3 +61,55 @@ func TestPickUserDedupsBySlackID(t *testing.T) {
 	assert.NoError(err)
 	assert.Equal(u1, picked)
 }
+
+func TestPickUsers(t *testing.T) {
+	assert := assert.New(t)
+
+	currentUser := whoswho.User{SlackID: "U99995"}
+	u1 := whoswho.User{SlackID: "U99991"}
+	u2 := whoswho.User{SlackID: "U99992"}
+	u3 := whoswho.User{SlackID: "U99993"}
+	users := []whoswho.User{currentUser, u1, u2, u2, u3}
+
+	t.Log("Picks distinct users")
+	picked, err := pickUsers(users, &currentUser, 3, strategyRandom, nil, rand.NewSource(0))
+	assert.NoError(err)
+	assert.ElementsMatch([]whoswho.User{u1, u2, u3}, picked)
+
+	t.Log("Picks fewer users if there aren't enough")
+	picked, err = pickUsers(users, &currentUser, 5, strategyRandom, nil, rand.NewSource(0))
+	assert.NoError(err)
+	assert.Len(picked, 3)
+
+	t.Log("Fails if there's nobody to pick")
+	_, err = pickUsers([]whoswho.User{currentUser}, &currentUser, 2, strategyRandom, nil, rand.NewSource(0))
+	assert.Equal(ErrNoUsers, err)
+}
+
+func TestPickUsersLeastRecent(t *testing.T) {
+	assert := assert.New(t)
+
+	u1 := whoswho.User{SlackID: "U99991"}
+	u2 := whoswho.User{SlackID: "U99992"}
+	u3 := whoswho.User{SlackID: "U99993"}
+	users := []whoswho.User{u1, u2, u3}
+	now := time.Now()
+	lastPicked := map[string]time.Time{
+		u1.SlackID: now.Add(-time.Hour),
+		u2.SlackID: now,
+		// u3 has never been picked
+	}
+
+	picked, err := pickUsers(users, nil, 1, strategyLeastRecent, lastPicked, rand.NewSource(0))
+	assert.NoError(err)
+	assert.Equal([]whoswho.User{u3}, picked)
+
+	picked, err = pickUsers(users, nil, 2, strategyLeastRecent, lastPicked, rand.NewSource(0))
+	assert.NoError(err)
+	assert.Equal([]whoswho.User{u3, u1}, picked)
+
+	t.Log("Omitted users aren't picked even if they're least recent")
+	picked, err = pickUsers(users, &u3, 1, strategyLeastRecent, lastPicked, rand.NewSource(0))
+	assert.NoError(err)
+	assert.Equal([]whoswho.User{u1}, picked)
+}
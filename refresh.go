@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"time"
+
+	"github.com/Clever/kayvee-go/logger"
+	whoswho "github.com/Clever/who-is-who/go-client"
+)
+
+const (
+	// defaultRefreshInterval is how often the cache is refreshed from the directory
+	defaultRefreshInterval = 60 * time.Minute
+	// minRefreshBackoff is how long to wait before retrying the first failed refresh
+	minRefreshBackoff = time.Minute
+	// defaultStaleAfter is how old cached data gets before a warning is posted
+	defaultStaleAfter = 6 * time.Hour
+)
+
+// directorySnapshot is the last directory data that was loaded successfully
+type directorySnapshot struct {
+	Teams     map[string][]whoswho.User `json:"teams"`
+	Overrides []Override                `json:"overrides"`
+	UserFlair map[string]string         `json:"user_flair"`
+	// Inactive is the Slack IDs of users the directory marks inactive
+	Inactive []string  `json:"inactive,omitempty"`
+	Taken    time.Time `json:"taken"`
+}
+
+// refreshTeams reloads teams from the directory, and overrides and flair from the bot's state.
+// Teams that were renamed keep their old name as an alias, and a notice is returned (and posted) for each.
+func (bot *Bot) refreshTeams() ([]string, error) {
+	snapshot, err := bot.Directory.Load()
+	if err != nil {
+		bot.lastRefresh.Store(&refreshResult{At: time.Now(), Err: err})
+		return nil, err
+	}
+
+	snapshot.Taken = time.Now()
+	if err := bot.saveSnapshot(snapshot); err != nil {
+		bot.Logger.ErrorD("save-snapshot-error", logger.M{"path": bot.SnapshotPath, "error": err.Error()})
+	}
+	notices, err := bot.applySnapshot(snapshot)
+	bot.lastRefresh.Store(&refreshResult{At: time.Now(), Err: err})
+	return notices, err
+}
+
+// restoreSnapshot fills the cache from the snapshot saved by the last successful refresh.
+// It's used when the directory is unavailable at startup.
+func (bot *Bot) restoreSnapshot() error {
+	if bot.SnapshotPath == "" {
+		return fmt.Errorf("no snapshot file is configured")
+	}
+	data, err := ioutil.ReadFile(bot.SnapshotPath)
+	if err != nil {
+		return err
+	}
+	snapshot := directorySnapshot{}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("error parsing snapshot file %s: %s", bot.SnapshotPath, err)
+	}
+	_, err = bot.applySnapshot(snapshot)
+	return err
+}
+
+func (bot *Bot) saveSnapshot(snapshot directorySnapshot) error {
+	if bot.SnapshotPath == "" {
+		return nil
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(bot.SnapshotPath, data)
+}
+
+// applySnapshot makes snapshot's teams current, detecting renamed teams and reporting what changed.
+// It returns notices about renamed teams followed by a line for each change.
+func (bot *Bot) applySnapshot(snapshot directorySnapshot) ([]string, error) {
+	var renames map[string]string
+	var diff cacheDiff
+	err := bot.updateCache(func(c *teamCache) error {
+		before := bot.cache()
+		overrides, userFlair, err := bot.loadOverridesAndFlair(snapshot.Teams, snapshot.Overrides, snapshot.UserFlair)
+		if err != nil {
+			return err
+		}
+		renames = detectTeamRenames(c.TeamToTeamMembers, snapshot.Teams)
+		c.TeamToTeamMembers = snapshot.Teams
+		c.TeamOverrides = overrides
+		c.UserFlair = userFlair
+		c.LastRefresh = snapshot.Taken
+		// the first load has nothing to compare with
+		if len(before.TeamToTeamMembers) > 0 {
+			diff = diffCaches(before, c, renames, snapshot.Inactive)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	notices := bot.aliasRenamedTeams(renames)
+	bot.notify(notices...)
+	return append(notices, bot.reportCacheDiff(diff)...), nil
+}
+
+// notify posts each notice to the notification channel, if there is one
+func (bot *Bot) notify(notices ...string) {
+	if bot.NotificationChannel == "" {
+		return
+	}
+	for _, notice := range notices {
+		err := bot.SlackEventsService.PostMessage(bot.NotificationChannel, notice)
+		if err != nil {
+			bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
+		}
+	}
+}
+
+// refreshLoop refreshes the cache every RefreshInterval forever. Failed refreshes are retried with
+// exponential backoff, and a warning is posted once the cached data is older than StaleAfter.
+func (bot *Bot) refreshLoop() {
+	interval := bot.refreshInterval()
+	failures := 0
+	warnedStale := false
+	for {
+		delay := time.Until(bot.cache().LastRefresh.Add(interval))
+		if failures > 0 {
+			delay = refreshBackoff(failures, interval, bot.RandomSource)
+		}
+		if delay > 0 {
+			time.Sleep(delay)
+		}
+
+		_, err := bot.refreshTeams()
+		if err == nil {
+			failures = 0
+			warnedStale = false
+			continue
+		}
+
+		failures++
+		bot.Logger.CriticalD("user cache refresh failed", logger.M{"error": err.Error(), "failures": failures})
+		if !warnedStale {
+			warnedStale = bot.warnIfStale(time.Now(), err)
+		}
+	}
+}
+
+func (bot *Bot) refreshInterval() time.Duration {
+	if bot.RefreshInterval > 0 {
+		return bot.RefreshInterval
+	}
+	return defaultRefreshInterval
+}
+
+// warnIfStale posts a warning if the cached data is older than StaleAfter, returning whether it did
+func (bot *Bot) warnIfStale(now time.Time, err error) bool {
+	staleAfter := bot.StaleAfter
+	if staleAfter <= 0 {
+		staleAfter = defaultStaleAfter
+	}
+	lastRefresh := bot.cache().LastRefresh
+	age := now.Sub(lastRefresh)
+	if age <= staleAfter {
+		return false
+	}
+
+	bot.Logger.WarnD("user cache stale", logger.M{"last-refresh": lastRefresh, "age": age.String()})
+	bot.notify(fmt.Sprintf("Heads up: my team data is from %s (%s ago), because I can't refresh it: %s",
+		lastRefresh.Format(time.RFC1123), age.Round(time.Minute), err))
+	return true
+}
+
+// refreshBackoff is how long to wait after a number of consecutive failed refreshes: it doubles with each
+// failure, from minRefreshBackoff up to interval, with jitter so restarted instances don't retry in step
+func refreshBackoff(failures int, interval time.Duration, source rand.Source) time.Duration {
+	backoff := minRefreshBackoff
+	for i := 1; i < failures && backoff < interval; i++ {
+		backoff *= 2
+	}
+	if backoff > interval {
+		backoff = interval
+	}
+	// wait between half and all of the backoff
+	half := int64(backoff / 2)
+	return time.Duration(half + rand.New(source).Int63n(half+1))
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	whoswho "github.com/Clever/who-is-who/go-client"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefreshSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pickabot-snapshot")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	mockbot, mocks, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+	mockbot.SnapshotPath = filepath.Join(dir, "snapshot.json")
+
+	t.Log("A successful refresh saves a snapshot")
+	mocks.WhoIsWhoClient.EXPECT().GetUserList().Return([]whoswho.User{
+		{SlackID: "U1", Active: true, Team: "Engineering - Infra", Pickabot: whoswho.Pickabot{Flair: ":wave:"}},
+	}, nil)
+	_, err = mockbot.refreshTeams()
+	assert.NoError(t, err)
+	refreshed := mockbot.cache().LastRefresh
+	assert.NoError(t, mockbot.lastRefresh.Load().Err)
+
+	t.Log("A failed refresh is recorded, and leaves the cache as it was")
+	mocks.WhoIsWhoClient.EXPECT().GetUserList().Return(nil, errors.New("who-is-who is down"))
+	_, err = mockbot.refreshTeams()
+	assert.Error(t, err)
+	assert.EqualError(t, mockbot.lastRefresh.Load().Err, "who-is-who is down")
+	assert.True(t, refreshed.Equal(mockbot.cache().LastRefresh))
+
+	t.Log("Another bot can start from the snapshot")
+	restored, _, restoredCtrl := getMockBot(t)
+	defer restoredCtrl.Finish()
+	restored.SnapshotPath = mockbot.SnapshotPath
+	assert.NoError(t, restored.restoreSnapshot())
+	assert.Equal(t, []string{"U1"}, slackIDs(restored.cache().TeamToTeamMembers["infra"]))
+	assert.Equal(t, ":wave:", restored.cache().UserFlair["U1"])
+	assert.True(t, refreshed.Equal(restored.cache().LastRefresh))
+
+	t.Log("Without a snapshot, there's nothing to start from")
+	restored.SnapshotPath = filepath.Join(dir, "missing.json")
+	assert.Error(t, restored.restoreSnapshot())
+	restored.SnapshotPath = ""
+	assert.Error(t, restored.restoreSnapshot())
+}
+
+func TestRefreshBackoff(t *testing.T) {
+	source := rand.NewSource(0)
+	for _, test := range []struct {
+		failures int
+		min, max time.Duration
+	}{
+		{1, 30 * time.Second, time.Minute},
+		{2, time.Minute, 2 * time.Minute},
+		{4, 4 * time.Minute, 8 * time.Minute},
+		{10, 30 * time.Minute, time.Hour},
+		{100, 30 * time.Minute, time.Hour},
+	} {
+		backoff := refreshBackoff(test.failures, time.Hour, source)
+		assert.True(t, backoff >= test.min && backoff <= test.max, "%d failures: %s", test.failures, backoff)
+	}
+}
+
+func TestWarnIfStale(t *testing.T) {
+	mockbot, mocks, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+	mockbot.NotificationChannel = "notices"
+	mockbot.StaleAfter = time.Hour
+	lastRefresh := time.Date(2030, 1, 2, 3, 0, 0, 0, time.UTC)
+	setCache(mockbot, func(c *teamCache) {
+		c.LastRefresh = lastRefresh
+	})
+
+	assert.False(t, mockbot.warnIfStale(lastRefresh.Add(30*time.Minute), errors.New("who-is-who is down")))
+
+	mocks.SlackEvents.EXPECT().PostMessage("notices", gomock.Any()).Do(func(channel, text string) {
+		assert.Equal(t, "Heads up: my team data is from Wed, 02 Jan 2030 03:00:00 UTC (2h0m0s ago), because I can't refresh it: who-is-who is down", text)
+	})
+	assert.True(t, mockbot.warnIfStale(lastRefresh.Add(2*time.Hour), errors.New("who-is-who is down")))
+}
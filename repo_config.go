@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/Clever/kayvee-go/logger"
+	"github.com/Clever/pickabot/github"
+	whoswho "github.com/Clever/who-is-who/go-client"
+	"github.com/slack-go/slack/slackevents"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// repoConfigPath is the file a repo keeps its reviewer rules in. It's read at the pull request's base ref,
+// so a pull request can't change its own rules.
+const repoConfigPath = ".pickabot.yml"
+
+// repoConfig is a repo's .pickabot.yml, for example:
+//
+//	team: infra
+//	reviewers: 2
+//	paths:
+//	  - path: db/
+//	    team: data
+//	exclude: [octocat]
+type repoConfig struct {
+	// Team reviews every pull request
+	Team string `yaml:"team"`
+	// Reviewers is how many people to pick from Team, default the team's setting
+	Reviewers int `yaml:"reviewers"`
+	// Paths require reviews from other teams for pull requests that change matching files
+	Paths []repoPathRule `yaml:"paths"`
+	// Exclude lists GitHub logins who are never picked
+	Exclude []string `yaml:"exclude"`
+}
+
+// repoPathRule requires a review from Team when a file matching Path changes.
+// A Path ending in "/" matches everything under it, and other paths are globs, e.g. "*.sql".
+type repoPathRule struct {
+	Path      string `yaml:"path"`
+	Team      string `yaml:"team"`
+	Reviewers int    `yaml:"reviewers"`
+}
+
+// repoReviewTeam is a team to pick from, and how many to pick: zero means the team's setting
+type repoReviewTeam struct {
+	Team      string
+	Reviewers int
+}
+
+func parseRepoConfig(data []byte) (repoConfig, error) {
+	config := repoConfig{}
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return config, err
+	}
+	if config.Team == "" && len(config.Paths) == 0 {
+		return config, fmt.Errorf("it needs a team or paths")
+	}
+	if config.Reviewers < 0 || config.Reviewers > maxReviewers {
+		return config, fmt.Errorf("reviewers must be a number from 1 to %d", maxReviewers)
+	}
+	for idx, rule := range config.Paths {
+		if rule.Path == "" || rule.Team == "" {
+			return config, fmt.Errorf("path %d needs a path and a team", idx+1)
+		}
+		if rule.Reviewers < 0 || rule.Reviewers > maxReviewers {
+			return config, fmt.Errorf("reviewers must be a number from 1 to %d", maxReviewers)
+		}
+		if _, err := path.Match(strings.TrimPrefix(rule.Path, "/"), ""); err != nil {
+			return config, fmt.Errorf("path %s isn't a valid pattern", rule.Path)
+		}
+	}
+	return config, nil
+}
+
+// pathMatches reports whether file matches a repoPathRule's Path. Patterns without a slash, e.g. "*.sql",
+// match files of that name in any directory.
+func pathMatches(pattern, file string) bool {
+	pattern = strings.TrimPrefix(pattern, "/")
+	if strings.HasSuffix(pattern, "/") {
+		return strings.HasPrefix(file, pattern)
+	}
+	if ok, _ := path.Match(pattern, file); ok {
+		return true
+	}
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(file))
+		return ok
+	}
+	return false
+}
+
+// reviewTeams returns the teams that review a pull request changing files: Team, then the team of each
+// matching path rule, each team once
+func (c repoConfig) reviewTeams(files []string) []repoReviewTeam {
+	teams := []repoReviewTeam{}
+	seen := map[string]struct{}{}
+	add := func(team string, reviewers int) {
+		if _, ok := seen[team]; !ok {
+			seen[team] = struct{}{}
+			teams = append(teams, repoReviewTeam{Team: team, Reviewers: reviewers})
+		}
+	}
+
+	if c.Team != "" {
+		add(c.Team, c.Reviewers)
+	}
+	for _, rule := range c.Paths {
+		for _, file := range files {
+			if pathMatches(rule.Path, file) {
+				add(rule.Team, rule.Reviewers)
+				break
+			}
+		}
+	}
+	return teams
+}
+
+// assignFromRepoConfig picks reviewers for each pull request in the message, as its repo's .pickabot.yml says
+func (bot *Bot) assignFromRepoConfig(ev *slackevents.MessageEvent) {
+	prs, duplicates := parseMessageForPRs(bot.githubHost(), bot.GithubOrgNames, ev.Text)
+	if len(prs) == 0 {
+		err := bot.SlackEventsService.PostMessage(ev.Channel, fmt.Sprintf("Sorry, I didn't see any %s pull requests to assign", strings.Join(bot.GithubOrgNames, " or ")))
+		if err != nil {
+			bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
+		}
+		return
+	}
+
+	for _, pr := range prs {
+		err := bot.SlackEventsService.PostMessage(ev.Channel, bot.assignPullRequestFromRepoConfig(ev, pr))
+		if err != nil {
+			bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
+		}
+	}
+	if len(duplicates) > 0 {
+		err := bot.SlackEventsService.PostMessage(ev.Channel, bot.duplicatePRsNote(ev.Text))
+		if err != nil {
+			bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
+		}
+	}
+}
+
+// assignPullRequestFromRepoConfig picks reviewers for pr as its repo's .pickabot.yml says, returning what happened
+func (bot *Bot) assignPullRequestFromRepoConfig(ev *slackevents.MessageEvent, pr githubPR) string {
+	url := pullRequestURL(bot.githubHost(), pr)
+	bot.Logger.InfoD("assign-from-repo-config", logger.M{"pr": url, "user": ev.User})
+
+	// the dev bot shouldn't hit the API, so it can't read the repo's config
+	if bot.DevMode {
+		return fmt.Sprintf("would have assigned %s as its %s says", url, repoConfigPath)
+	}
+	// check the pull request before picking anyone, so skipped ones don't count as picks
+	pull, err := bot.GithubClient.GetPullRequestStatus(context.Background(), pr.Owner, pr.Repo, pr.PRNumber)
+	skip, warning := bot.pullRequestProblem(pr, pull, err)
+	if skip != "" {
+		return fmt.Sprintf("I skipped %s: %s", url, skip)
+	}
+	data, err := bot.GithubClient.GetFileContents(context.Background(), pr.Owner, pr.Repo, repoConfigPath, pull.Base.GetRef())
+	if err == github.ErrNotFound {
+		return fmt.Sprintf("%s has no %s, so I don't know who to pick. Try `assign <team> for %s`", pr.Repo, repoConfigPath, url)
+	} else if err != nil {
+		bot.Logger.ErrorD("get-repo-config-error", logger.M{"error": err.Error(), "pr": url})
+		return fmt.Sprintf("Sorry, I couldn't read %s's %s: %s", pr.Repo, repoConfigPath, err)
+	}
+	config, err := parseRepoConfig(data)
+	if err != nil {
+		return fmt.Sprintf("Sorry, %s's %s is invalid: %s", pr.Repo, repoConfigPath, err)
+	}
+
+	files := []string{}
+	if len(config.Paths) > 0 {
+		changed, err := bot.GithubClient.ListPullRequestFiles(context.Background(), pr.Owner, pr.Repo, pr.PRNumber)
+		if err != nil {
+			bot.Logger.ErrorD("list-pull-request-files-error", logger.M{"error": err.Error(), "pr": url})
+			return fmt.Sprintf("Sorry, I couldn't list the files %s changes: %s", url, err)
+		}
+		for _, f := range changed {
+			files = append(files, f.GetFilename())
+		}
+	}
+	reviewTeams := config.reviewTeams(files)
+	if len(reviewTeams) == 0 {
+		return fmt.Sprintf("%s's %s doesn't ask for reviewers for the files %s changes", pr.Repo, repoConfigPath, url)
+	}
+
+	excluded := append([]string{pull.User.GetLogin()}, config.Exclude...)
+	picked := []whoswho.User{}
+	// each team's picks are assigned as the team's own assign setting says
+	type teamPicks struct {
+		users  []whoswho.User
+		assign string
+	}
+	picks := []teamPicks{}
+	for _, rt := range reviewTeams {
+		team, err := bot.findMatchingTeam(rt.Team)
+		if err != nil {
+			return fmt.Sprintf("Sorry, %s's %s names team %s: %s", pr.Repo, repoConfigPath, rt.Team, err)
+		}
+		settings := bot.teamSettings(team)
+		n := rt.Reviewers
+		if n == 0 {
+			n = settings.reviewers()
+		}
+		omit := &whoswho.User{SlackID: ev.User}
+		if settings.IncludeRequester {
+			omit = nil
+		}
+
+		candidates := []whoswho.User{}
+		for _, u := range bot.buildTeam(team) {
+			if !containsFold(excluded, u.Github) && !containsSlackID(picked, u.SlackID) {
+				candidates = append(candidates, u)
+			}
+		}
+		users, err := pickUsers(candidates, omit, n, settings.strategy(), bot.lastPicked(), bot.RandomSource)
+		if err != nil {
+			bot.Logger.ErrorD("pick-user-error", logger.M{"error": err.Error(), "pr": url, "team": team})
+			return fmt.Sprintf("Sorry, there's nobody left on team %s to review %s", team, url)
+		}
+		picked = append(picked, users...)
+		picks = append(picks, teamPicks{users: users, assign: settings.assign()})
+	}
+
+	assigned := []whoswho.User{}
+	for _, p := range picks {
+		if err := bot.assignPullRequest(ev, pr, p.users, p.assign); err != nil {
+			bot.recordPicks(assigned)
+			return fmt.Sprintf("Error setting %s as pull-request reviewer: %s", bot.userMentions(p.users), err.Error())
+		}
+		assigned = append(assigned, p.users...)
+	}
+	bot.recordPicks(assigned)
+
+	text := fmt.Sprintf("Set %s as pull-request reviewer of %s, as its %s says", bot.userMentions(picked), url, repoConfigPath)
+	if warning != "" {
+		text += "\n" + warning
+	}
+	return text
+}
+
+// containsFold reports whether values contains s, ignoring case. An empty s is never contained.
+func containsFold(values []string, s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsSlackID(users []whoswho.User, slackID string) bool {
+	for _, u := range users {
+		if u.SlackID == slackID {
+			return true
+		}
+	}
+	return false
+}
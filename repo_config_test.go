@@ -0,0 +1,180 @@
+package main
+
+import (
+	"testing"
+
+	pickabotgithub "github.com/Clever/pickabot/github"
+	whoswho "github.com/Clever/who-is-who/go-client"
+	"github.com/golang/mock/gomock"
+	"github.com/google/go-github/github"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRepoConfig(t *testing.T) {
+	config, err := parseRepoConfig([]byte(`
+team: infra
+reviewers: 2
+paths:
+  - path: db/
+    team: data
+exclude: [octocat]
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, repoConfig{
+		Team:      "infra",
+		Reviewers: 2,
+		Paths:     []repoPathRule{{Path: "db/", Team: "data"}},
+		Exclude:   []string{"octocat"},
+	}, config)
+
+	for _, invalid := range []string{
+		"reviewers: 2",
+		"team: infra\nreviewers: 11",
+		"team: infra\nreviewer: 2",
+		"paths:\n  - path: db/",
+		"paths:\n  - path: \"[\"\n    team: data",
+		"team: [infra",
+	} {
+		_, err := parseRepoConfig([]byte(invalid))
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestRepoConfigReviewTeams(t *testing.T) {
+	config := repoConfig{
+		Team: "infra",
+		Paths: []repoPathRule{
+			{Path: "db/", Team: "data", Reviewers: 2},
+			{Path: "*.sql", Team: "data"},
+			{Path: "/launch/*.yml", Team: "ops"},
+			{Path: "docs/", Team: "infra"},
+		},
+	}
+
+	assert.Equal(t, []repoReviewTeam{{Team: "infra"}}, config.reviewTeams([]string{"main.go"}))
+	assert.Equal(t, []repoReviewTeam{{Team: "infra"}, {Team: "data"}}, config.reviewTeams([]string{"schema/tables.sql"}))
+	assert.Equal(t, []repoReviewTeam{{Team: "infra"}, {Team: "data", Reviewers: 2}, {Team: "ops"}},
+		config.reviewTeams([]string{"db/migrate.go", "launch/app.yml", "docs/README.md"}))
+	assert.Equal(t, []repoReviewTeam{{Team: "infra"}}, config.reviewTeams([]string{"launch/nested/app.yml", "dbx/main.go"}))
+}
+
+func TestAssignFromRepoConfig(t *testing.T) {
+	pull := pickabotgithub.PullRequestStatus{
+		State: "open",
+		Base:  &github.PullRequestBranch{Ref: github.String("master")},
+		User:  &github.User{Login: github.String("author")},
+	}
+
+	for _, test := range []struct {
+		name            string
+		expectations    func(*BotMocks)
+		expectedMessage string
+	}{
+		{
+			name: "picks from the repo's team, without excluded users",
+			expectations: func(mocks *BotMocks) {
+				mocks.GithubClient.EXPECT().GetPullRequestStatus(gomock.Any(), testGithubOrg, "fake-repo", 1).Return(pull, nil)
+				mocks.GithubClient.EXPECT().GetFileContents(gomock.Any(), testGithubOrg, "fake-repo", ".pickabot.yml", "master").
+					Return([]byte("team: github-user-team\nexclude: [G2Github]\n"), nil)
+				mocks.GithubClient.EXPECT().AddAssignees(gomock.Any(), testGithubOrg, "fake-repo", 1, []string{"github"})
+				mocks.GithubClient.EXPECT().AddReviewers(gomock.Any(), testGithubOrg, "fake-repo", 1, []string{"github"})
+			},
+			expectedMessage: "Set <@G1> as pull-request reviewer of https://github.com/Clever/fake-repo/pull/1, as its .pickabot.yml says",
+		},
+		{
+			name: "adds a team for matching paths, and the requester can't review",
+			expectations: func(mocks *BotMocks) {
+				mocks.GithubClient.EXPECT().GetPullRequestStatus(gomock.Any(), testGithubOrg, "fake-repo", 1).Return(pull, nil)
+				mocks.GithubClient.EXPECT().GetFileContents(gomock.Any(), testGithubOrg, "fake-repo", ".pickabot.yml", "master").
+					Return([]byte("team: github-user-team\npaths:\n  - path: \"*.sql\"\n    team: same-user-team\n"), nil)
+				mocks.GithubClient.EXPECT().ListPullRequestFiles(gomock.Any(), testGithubOrg, "fake-repo", 1).
+					Return([]*github.CommitFile{{Filename: github.String("db/schema.sql")}}, nil)
+			},
+			expectedMessage: "Sorry, there's nobody left on team same-user-team to review https://github.com/Clever/fake-repo/pull/1",
+		},
+		{
+			name: "closed pull requests are skipped before picking",
+			expectations: func(mocks *BotMocks) {
+				closed := pull
+				closed.State = "closed"
+				mocks.GithubClient.EXPECT().GetPullRequestStatus(gomock.Any(), testGithubOrg, "fake-repo", 1).Return(closed, nil)
+			},
+			expectedMessage: "I skipped https://github.com/Clever/fake-repo/pull/1: it's closed",
+		},
+		{
+			name: "repos without a config need a team",
+			expectations: func(mocks *BotMocks) {
+				mocks.GithubClient.EXPECT().GetPullRequestStatus(gomock.Any(), testGithubOrg, "fake-repo", 1).Return(pull, nil)
+				mocks.GithubClient.EXPECT().GetFileContents(gomock.Any(), testGithubOrg, "fake-repo", ".pickabot.yml", "master").
+					Return(nil, pickabotgithub.ErrNotFound)
+			},
+			expectedMessage: "fake-repo has no .pickabot.yml, so I don't know who to pick. Try `assign <team> for https://github.com/Clever/fake-repo/pull/1`",
+		},
+		{
+			name: "invalid configs are reported",
+			expectations: func(mocks *BotMocks) {
+				mocks.GithubClient.EXPECT().GetPullRequestStatus(gomock.Any(), testGithubOrg, "fake-repo", 1).Return(pull, nil)
+				mocks.GithubClient.EXPECT().GetFileContents(gomock.Any(), testGithubOrg, "fake-repo", ".pickabot.yml", "master").
+					Return([]byte("team: github-user-team\nreviewers: 20\n"), nil)
+			},
+			expectedMessage: "Sorry, fake-repo's .pickabot.yml is invalid: reviewers must be a number from 1 to 10",
+		},
+	} {
+		t.Logf("Case: %s", test.name)
+		mockbot, mocks, mockCtrl := getMockBot(t)
+		defer mockCtrl.Finish()
+
+		test.expectations(mocks)
+		mocks.SlackEvents.EXPECT().PostMessage(testChannel, test.expectedMessage)
+		mockbot.DecodeMessage(makeSlackMessage("<@U1234> assign for <https://github.com/Clever/fake-repo/pull/1>"))
+	}
+
+	for _, message := range []string{"<@U1234> assign for fake-repo#1", "<@U1234> assign Clever/fake-repo#1"} {
+		t.Logf("Case: short pull request reference. Input: %s", message)
+		mockbot, mocks, mockCtrl := getMockBot(t)
+		defer mockCtrl.Finish()
+
+		mocks.GithubClient.EXPECT().GetPullRequestStatus(gomock.Any(), testGithubOrg, "fake-repo", 1).Return(pull, nil)
+		mocks.GithubClient.EXPECT().GetFileContents(gomock.Any(), testGithubOrg, "fake-repo", ".pickabot.yml", "master").
+			Return([]byte("team: github-user-team\nexclude: [G2Github]\n"), nil)
+		mocks.GithubClient.EXPECT().AddAssignees(gomock.Any(), testGithubOrg, "fake-repo", 1, []string{"github"})
+		mocks.GithubClient.EXPECT().AddReviewers(gomock.Any(), testGithubOrg, "fake-repo", 1, []string{"github"})
+		mocks.SlackEvents.EXPECT().PostMessage(testChannel, "Set <@G1> as pull-request reviewer of https://github.com/Clever/fake-repo/pull/1, as its .pickabot.yml says")
+		mockbot.DecodeMessage(makeSlackMessage(message))
+	}
+}
+
+func TestAssignFromRepoConfigTeamSettings(t *testing.T) {
+	pull := pickabotgithub.PullRequestStatus{
+		State: "open",
+		Base:  &github.PullRequestBranch{Ref: github.String("master")},
+		User:  &github.User{Login: github.String("author")},
+	}
+	mockbot, mocks, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+	setCache(mockbot, func(c *teamCache) {
+		c.TeamToTeamMembers["data-team"] = []whoswho.User{{SlackID: "G3", Github: "G3Github"}}
+	})
+	mockbot.State.TeamSettings = map[string]teamSettings{
+		"github-user-team": {Assign: assignReviewer},
+		"data-team":        {Assign: assignAssignee},
+	}
+
+	t.Log("Each team's picks are assigned as the team's settings say")
+	mocks.GithubClient.EXPECT().GetPullRequestStatus(gomock.Any(), testGithubOrg, "fake-repo", 1).Return(pull, nil)
+	mocks.GithubClient.EXPECT().GetFileContents(gomock.Any(), testGithubOrg, "fake-repo", ".pickabot.yml", "master").
+		Return([]byte("team: github-user-team\nexclude: [G2Github]\npaths:\n  - path: db/\n    team: data-team\n"), nil)
+	mocks.GithubClient.EXPECT().ListPullRequestFiles(gomock.Any(), testGithubOrg, "fake-repo", 1).
+		Return([]*github.CommitFile{{Filename: github.String("db/schema.sql")}}, nil)
+	gomock.InOrder(
+		mocks.GithubClient.EXPECT().AddReviewers(gomock.Any(), testGithubOrg, "fake-repo", 1, []string{"github"}),
+		mocks.GithubClient.EXPECT().AddAssignees(gomock.Any(), testGithubOrg, "fake-repo", 1, []string{"G3Github"}),
+	)
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, "Set <@G1>, <@G3> as pull-request reviewer of https://github.com/Clever/fake-repo/pull/1, as its .pickabot.yml says")
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> assign for fake-repo#1"))
+
+	t.Log("The dev bot doesn't read the repo's config")
+	mockbot.DevMode = true
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, "would have assigned https://github.com/Clever/fake-repo/pull/1 as its .pickabot.yml says")
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> assign for fake-repo#1"))
+}
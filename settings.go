@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Clever/kayvee-go/logger"
+	whoswho "github.com/Clever/who-is-who/go-client"
+	"github.com/slack-go/slack/slackevents"
+)
+
+const (
+	// assignBoth sets picked users as both assignee and reviewer of pull requests
+	assignBoth = "both"
+	// assignAssignee only sets picked users as assignee
+	assignAssignee = "assignee"
+	// assignReviewer only requests reviews from picked users
+	assignReviewer = "reviewer"
+)
+
+// maxReviewers limits how many people can be picked at once
+const maxReviewers = 10
+
+// teamSettings customize how pickabot picks for a team. Zero values mean the defaults.
+type teamSettings struct {
+	// Strategy is strategyRandom (the default) or strategyLeastRecent
+	Strategy string `json:"strategy,omitempty"`
+	// Reviewers is how many people to pick, default 1
+	Reviewers int `json:"reviewers,omitempty"`
+	// IncludeRequester allows the person asking for a pick to be picked
+	IncludeRequester bool `json:"include_requester,omitempty"`
+	// Channel is the ID of the team's Slack channel, which is told about PR assignments
+	Channel string `json:"channel,omitempty"`
+	// Assign is assignBoth (the default), assignAssignee or assignReviewer
+	Assign string `json:"assign,omitempty"`
+	// GithubTeam is the slug of the GitHub team whose review requests are passed on to one of the team's members
+	GithubTeam string `json:"github_team,omitempty"`
+}
+
+func (s teamSettings) reviewers() int {
+	if s.Reviewers < 1 {
+		return 1
+	}
+	return s.Reviewers
+}
+
+func (s teamSettings) strategy() string {
+	if s.Strategy == "" {
+		return strategyRandom
+	}
+	return s.Strategy
+}
+
+func (s teamSettings) assign() string {
+	if s.Assign == "" {
+		return assignBoth
+	}
+	return s.Assign
+}
+
+func (s teamSettings) String() string {
+	channel := "none"
+	if s.Channel != "" {
+		channel = fmt.Sprintf("<#%s>", s.Channel)
+	}
+	githubTeam := "none"
+	if s.GithubTeam != "" {
+		githubTeam = s.GithubTeam
+	}
+	return fmt.Sprintf("reviewers=%d strategy=%s exclude-requester=%t channel=%s assign=%s github-team=%s",
+		s.reviewers(), s.strategy(), !s.IncludeRequester, channel, s.assign(), githubTeam)
+}
+
+var settingRegex = regexp.MustCompile(`([a-z-]+)=(\S+)`)
+var channelMentionRegex = regexp.MustCompile(`^<#([A-Z0-9]+)(?:\|[^>]*)?>$`)
+var githubTeamSlugRegex = regexp.MustCompile(`^[a-z0-9_.-]+$`)
+
+// applySettings updates settings with "key=value" pairs, e.g. "reviewers=2 strategy=least-recent"
+func applySettings(settings teamSettings, pairs string) (teamSettings, error) {
+	for _, match := range settingRegex.FindAllStringSubmatch(pairs, -1) {
+		key, value := match[1], match[2]
+		switch key {
+		case "reviewers":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > maxReviewers {
+				return settings, fmt.Errorf("reviewers must be a number from 1 to %d", maxReviewers)
+			}
+			settings.Reviewers = n
+		case "strategy":
+			if value != strategyRandom && value != strategyLeastRecent {
+				return settings, fmt.Errorf("strategy must be %s or %s", strategyRandom, strategyLeastRecent)
+			}
+			settings.Strategy = value
+		case "exclude-requester":
+			exclude, err := strconv.ParseBool(value)
+			if err != nil {
+				return settings, fmt.Errorf("exclude-requester must be true or false")
+			}
+			settings.IncludeRequester = !exclude
+		case "channel":
+			if value == "none" {
+				settings.Channel = ""
+				continue
+			}
+			channelMatch := channelMentionRegex.FindStringSubmatch(value)
+			if len(channelMatch) < 2 {
+				return settings, fmt.Errorf("channel must be a #channel, or none")
+			}
+			settings.Channel = channelMatch[1]
+		case "assign":
+			if value != assignBoth && value != assignAssignee && value != assignReviewer {
+				return settings, fmt.Errorf("assign must be %s, %s or %s", assignBoth, assignAssignee, assignReviewer)
+			}
+			settings.Assign = value
+		case "github-team":
+			if value == "none" {
+				settings.GithubTeam = ""
+				continue
+			}
+			if !githubTeamSlugRegex.MatchString(value) {
+				return settings, fmt.Errorf("github-team must be a GitHub team's slug, e.g. eng-infra, or none")
+			}
+			settings.GithubTeam = value
+		default:
+			return settings, fmt.Errorf("I don't know the setting %s", key)
+		}
+	}
+	return settings, nil
+}
+
+// teamSettings returns the settings for team
+func (bot *Bot) teamSettings(team string) teamSettings {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+
+	return bot.State.TeamSettings[team]
+}
+
+// teamForGithubTeam returns the team configured for a GitHub team's slug, if there is one
+func (bot *Bot) teamForGithubTeam(slug string) (string, bool) {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+
+	for team, settings := range bot.State.TeamSettings {
+		if strings.EqualFold(settings.GithubTeam, slug) {
+			return team, true
+		}
+	}
+	return "", false
+}
+
+// configureTeam changes a team's settings, or shows them if pairs is empty
+func (bot *Bot) configureTeam(ev *slackevents.MessageEvent, teamName, pairs string) {
+	bot.Logger.InfoD("configure-team", logger.M{"team": teamName, "settings": pairs, "user": ev.User})
+
+	actualTeamName, err := bot.findMatchingTeam(teamName)
+	if err != nil {
+		bot.reportTeamLookupError(ev, err, func(team string) {
+			bot.configureTeam(ev, team, pairs)
+		})
+		return
+	}
+
+	var text string
+	settings, err := applySettings(bot.teamSettings(actualTeamName), pairs)
+	if strings.TrimSpace(pairs) == "" {
+		text = fmt.Sprintf("Team %s has settings: %s", actualTeamName, settings)
+	} else if err != nil {
+		text = fmt.Sprintf("Sorry, %s. I know about: reviewers=<n> strategy=random|least-recent exclude-requester=true|false channel=#channel|none assign=both|assignee|reviewer github-team=<slug>|none", err)
+	} else if !bot.canModifyTeam(ev.User, "", actualTeamName) {
+		bot.Logger.WarnD("configure-team-denied", logger.M{"requester": ev.User, "team": actualTeamName})
+		text = fmt.Sprintf("Sorry, only members of team %s or a pickabot admin can change its settings", actualTeamName)
+	} else if err := bot.updateState(func(state *botState) {
+		state.TeamSettings[actualTeamName] = settings
+	}); err != nil {
+		bot.Logger.ErrorD("configure-team-error", logger.M{"error": err.Error(), "event-text": ev.Text})
+		text = fmt.Sprintf("Sorry, I couldn't save team %s's settings: %s", actualTeamName, err)
+	} else {
+		text = fmt.Sprintf("OK, team %s now has settings: %s", actualTeamName, settings)
+	}
+
+	err = bot.SlackEventsService.PostMessage(ev.Channel, text)
+	if err != nil {
+		bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
+	}
+}
+
+// lastPicked returns when each user was last picked
+func (bot *Bot) lastPicked() map[string]time.Time {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+
+	lastPicked := map[string]time.Time{}
+	for slackID, t := range bot.State.PickHistory {
+		lastPicked[slackID] = t
+	}
+	return lastPicked
+}
+
+// recordPicks remembers when users were picked, for strategyLeastRecent. Saving means writing the whole state
+// file, so rather than on every pick, the history is saved pickHistorySaveDelay after the first unsaved pick.
+// Picks since the last save are lost if pickabot exits before then.
+func (bot *Bot) recordPicks(users []whoswho.User) {
+	if len(users) == 0 {
+		return
+	}
+	now := time.Now()
+	stateLock.Lock()
+	defer stateLock.Unlock()
+
+	if bot.State.PickHistory == nil {
+		bot.State.PickHistory = map[string]time.Time{}
+	}
+	for _, u := range users {
+		bot.State.PickHistory[u.SlackID] = now
+	}
+	if bot.pickHistorySave == nil {
+		bot.pickHistorySave = time.AfterFunc(pickHistorySaveDelay, bot.savePickHistory)
+	}
+}
+
+// savePickHistory saves the state, including picks recordPicks hasn't saved yet
+func (bot *Bot) savePickHistory() {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+
+	bot.pickHistorySave = nil
+	if err := bot.StateStore.Save(bot.State); err != nil {
+		bot.Logger.ErrorD("record-picks-error", logger.M{"error": err.Error()})
+	}
+}
+
+// userMentions formats users as "<@U1> :flair:, <@U2>"
+func (bot *Bot) userMentions(users []whoswho.User) string {
+	userFlair := bot.cache().UserFlair
+	mentions := []string{}
+	for _, u := range users {
+		mention := fmt.Sprintf("<@%s>", u.SlackID)
+		if flair := userFlair[u.SlackID]; flair != "" {
+			mention += " " + flair
+		}
+		mentions = append(mentions, mention)
+	}
+	sort.Strings(mentions)
+	return strings.Join(mentions, ", ")
+}
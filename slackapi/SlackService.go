@@ -29,6 +29,7 @@ func (s *SlackAPIServer) GetUserInfo(user string) (*slack.User, error) {
 // Used to send messages to Slack channels
 type SlackEventsService interface {
 	PostMessage(channel string, text string) error
+	PostBlocks(channel string, text string, blocks ...slack.Block) error
 }
 
 // SlackEventsClient wraps the socketmode client
@@ -43,3 +44,12 @@ func (s *SlackEventsClient) PostMessage(channel string, text string) error {
 	}
 	return nil
 }
+
+// PostBlocks sends a Block Kit message, with text as the notification fallback
+func (s *SlackEventsClient) PostBlocks(channel string, text string, blocks ...slack.Block) error {
+	_, _, err := s.Client.PostMessage(channel, slack.MsgOptionText(text, false), slack.MsgOptionBlocks(blocks...))
+	if err != nil {
+		return fmt.Errorf("failed to send message: %s", err)
+	}
+	return nil
+}
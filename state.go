@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	whoswho "github.com/Clever/who-is-who/go-client"
+)
+
+// botState is pickabot's own configuration, which doesn't live in who-is-who
+type botState struct {
+	// Aliases maps an alternate name to the team it stands for
+	Aliases map[string]string `json:"aliases,omitempty"`
+	// Composites maps a composite team to the teams it's made of
+	Composites map[string][]string `json:"composites,omitempty"`
+	// TeamSettings maps a team to how pickabot picks for it
+	TeamSettings map[string]teamSettings `json:"team_settings,omitempty"`
+	// PickHistory maps a Slack ID to when that user was last picked
+	PickHistory map[string]time.Time `json:"pick_history,omitempty"`
+	// Overrides are all team overrides
+	Overrides []Override `json:"overrides,omitempty"`
+	// Flair maps a Slack ID to that user's flair
+	Flair map[string]string `json:"flair,omitempty"`
+	// DirectoryImported is set once overrides and flair have been copied from the directory,
+	// after which the state, not the directory, is their system of record
+	DirectoryImported bool `json:"directory_imported,omitempty"`
+}
+
+// clone returns a deep copy, so changes can be made without affecting the original
+func (s botState) clone() botState {
+	c := botState{
+		Aliases:      map[string]string{},
+		Composites:   map[string][]string{},
+		TeamSettings: map[string]teamSettings{},
+		PickHistory:  map[string]time.Time{},
+		Overrides:    append([]Override{}, s.Overrides...),
+		Flair:        map[string]string{},
+
+		DirectoryImported: s.DirectoryImported,
+	}
+	for alias, team := range s.Aliases {
+		c.Aliases[alias] = team
+	}
+	for team, members := range s.Composites {
+		c.Composites[team] = append([]string{}, members...)
+	}
+	for team, settings := range s.TeamSettings {
+		c.TeamSettings[team] = settings
+	}
+	for slackID, t := range s.PickHistory {
+		c.PickHistory[slackID] = t
+	}
+	for slackID, flair := range s.Flair {
+		c.Flair[slackID] = flair
+	}
+	return c
+}
+
+// stateStore persists botState between restarts
+type stateStore interface {
+	Load() (botState, error)
+	Save(botState) error
+}
+
+// fileStateStore keeps state as JSON in a local file
+type fileStateStore struct {
+	Path string
+}
+
+// Load reads the state file, returning empty state if it doesn't exist yet
+func (f *fileStateStore) Load() (botState, error) {
+	state := botState{}
+	data, err := ioutil.ReadFile(f.Path)
+	if os.IsNotExist(err) {
+		return state.clone(), nil
+	} else if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("error parsing state file %s: %s", f.Path, err)
+	}
+	return state.clone(), nil
+}
+
+// Save atomically replaces the state file, so a crash never leaves it half-written
+func (f *fileStateStore) Save(state botState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(f.Path, data)
+}
+
+// writeFileAtomically writes data to a temporary file next to path, then renames it to path
+func writeFileAtomically(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// memoryStateStore keeps state in memory only. It's used for development and tests.
+type memoryStateStore struct {
+	state botState
+}
+
+// Load returns the last saved state
+func (m *memoryStateStore) Load() (botState, error) {
+	return m.state.clone(), nil
+}
+
+// Save remembers state until the process exits
+func (m *memoryStateStore) Save(state botState) error {
+	m.state = state.clone()
+	return nil
+}
+
+var stateLock = &sync.Mutex{}
+
+// pickHistorySaveDelay is how long picks are kept in memory before they're saved, so picks close together
+// are saved together
+const pickHistorySaveDelay = 10 * time.Second
+
+// updateState applies update to a copy of the bot's state, persists it, and
+// only then makes it current, so a failed save leaves the state unchanged
+func (bot *Bot) updateState(update func(*botState)) error {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+
+	next := bot.State.clone()
+	update(&next)
+	if err := bot.StateStore.Save(next); err != nil {
+		return err
+	}
+	bot.State = next
+	return nil
+}
+
+// loadOverridesAndFlair returns the bot's overrides and flair from its state. The first time, when the state has
+// none yet, they're imported from the directory's overrides and userFlair instead, and saved to the state.
+// Overrides' users are updated with the directory's latest information about them.
+func (bot *Bot) loadOverridesAndFlair(teams map[string][]whoswho.User, overrides []Override, userFlair map[string]string) ([]Override, map[string]string, error) {
+	stateLock.Lock()
+	imported := bot.State.DirectoryImported
+	stateLock.Unlock()
+	if !imported {
+		err := bot.updateState(func(state *botState) {
+			state.Overrides = overrides
+			state.Flair = userFlair
+			state.DirectoryImported = true
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	users := map[string]whoswho.User{}
+	for _, members := range teams {
+		for _, u := range members {
+			users[u.SlackID] = u
+		}
+	}
+
+	stateLock.Lock()
+	state := bot.State.clone()
+	stateLock.Unlock()
+	for idx, o := range state.Overrides {
+		if u, ok := users[o.User.SlackID]; ok {
+			state.Overrides[idx].User = u
+		}
+	}
+	return state.Overrides, state.Flair, nil
+}
+
+// saveOverrides saves overrides to the bot's state
+func (bot *Bot) saveOverrides(overrides []Override) error {
+	return bot.updateState(func(state *botState) {
+		state.Overrides = append([]Override{}, overrides...)
+	})
+}
+
+// saveFlair saves userFlair to the bot's state
+func (bot *Bot) saveFlair(userFlair map[string]string) error {
+	return bot.updateState(func(state *botState) {
+		state.Flair = map[string]string{}
+		for slackID, flair := range userFlair {
+			state.Flair[slackID] = flair
+		}
+	})
+}
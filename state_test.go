@@ -0,0 +1,133 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	whoswho "github.com/Clever/who-is-who/go-client"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileStateStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pickabot-state")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	store := &fileStateStore{Path: filepath.Join(dir, "state.json")}
+
+	t.Log("Loads empty state when there's no file yet")
+	state, err := store.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{}, state.Aliases)
+
+	t.Log("Saved state can be loaded again")
+	state.Aliases["plat"] = "infra"
+	assert.NoError(t, store.Save(state))
+	loaded, err := store.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, state, loaded)
+
+	t.Log("Saving leaves no temporary files behind")
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(files))
+
+	t.Log("A corrupt file is an error")
+	assert.NoError(t, ioutil.WriteFile(store.Path, []byte("{"), 0644))
+	_, err = store.Load()
+	assert.Error(t, err)
+}
+
+func TestUpdateStateKeepsStateOnSaveError(t *testing.T) {
+	mockbot, _, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+	mockbot.StateStore = &fileStateStore{Path: filepath.Join("does-not-exist", "state.json")}
+
+	err := mockbot.updateState(func(state *botState) {
+		state.Aliases["plat"] = "infra"
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 0, len(mockbot.State.Aliases))
+}
+
+func TestOverridesAndFlairAreSavedToState(t *testing.T) {
+	mockbot, mocks, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+	mockbot.AdminSlackIDs = []string{testUserID}
+	mockbot.SyncDirectory = false
+
+	// without directory sync, who-is-who isn't called
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, gomock.Any()).Times(2)
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> add <@U5> to example-team"))
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> add flair :dance:"))
+
+	saved, err := mockbot.StateStore.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(saved.Overrides))
+	assert.Equal(t, "U5", saved.Overrides[0].User.SlackID)
+	assert.Equal(t, "example-team", saved.Overrides[0].Team)
+	assert.Equal(t, map[string]string{testUserID: ":dance:"}, saved.Flair)
+}
+
+func TestOverrideSaveErrorIsReported(t *testing.T) {
+	mockbot, mocks, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+	mockbot.AdminSlackIDs = []string{testUserID}
+	mockbot.StateStore = &fileStateStore{Path: filepath.Join("does-not-exist", "state.json")}
+
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, gomock.Any()).Do(func(channel, text string) {
+		assert.Contains(t, text, "Sorry, I couldn't save that change")
+	}).Times(2)
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> add <@U5> to example-team"))
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> add flair :dance:"))
+
+	assert.Equal(t, 0, len(mockbot.cache().TeamOverrides))
+	assert.Equal(t, 0, len(mockbot.cache().UserFlair))
+}
+
+func TestUndoKeptOnSaveError(t *testing.T) {
+	mockbot, mocks, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+	mockbot.recordUndo(testUserID, undoEntry{Overrides: []overrideChange{{SlackID: "U5555", Team: "example-team"}}})
+	setCache(mockbot, func(c *teamCache) {
+		c.TeamOverrides = []Override{{User: whoswho.User{SlackID: "U5555"}, Team: "example-team", Include: true}}
+	})
+
+	mockbot.StateStore = &fileStateStore{Path: filepath.Join("does-not-exist", "state.json")}
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, gomock.Any()).Do(func(channel, text string) {
+		assert.Contains(t, text, "Sorry, I couldn't save that change")
+	})
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> undo"))
+	assert.Equal(t, 1, len(mockbot.cache().TeamOverrides))
+
+	t.Log("The undo can be retried once saving works")
+	mockbot.StateStore = &memoryStateStore{}
+	mocks.WhoIsWhoClient.EXPECT().UserBySlackID("U5555")
+	mocks.WhoIsWhoClient.EXPECT().UpsertUser("pickabot", gomock.Any())
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, "Restored <@U5555>'s previous membership of team example-team")
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> undo"))
+	assert.Equal(t, []Override{}, mockbot.cache().TeamOverrides)
+}
+
+func TestLoadOverridesAndFlair(t *testing.T) {
+	mockbot, _, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+
+	teams := map[string][]whoswho.User{"infra": {{SlackID: "U5", Github: "u5"}}}
+	directoryOverrides := []Override{{User: whoswho.User{SlackID: "U5"}, Team: "data", Include: true}}
+
+	t.Log("The first load imports overrides and flair from the directory")
+	overrides, userFlair, err := mockbot.loadOverridesAndFlair(teams, directoryOverrides, map[string]string{"U5": ":wave:"})
+	assert.NoError(t, err)
+	assert.True(t, mockbot.State.DirectoryImported)
+	assert.Equal(t, "u5", overrides[0].User.Github)
+	assert.Equal(t, ":wave:", userFlair["U5"])
+
+	t.Log("After that, the state is the system of record")
+	overrides, userFlair, err = mockbot.loadOverridesAndFlair(teams, []Override{}, map[string]string{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(overrides))
+	assert.Equal(t, ":wave:", userFlair["U5"])
+}
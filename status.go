@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Clever/kayvee-go/logger"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// refreshResult is the outcome of a refresh of the cache
+type refreshResult struct {
+	At  time.Time
+	Err error
+}
+
+// setSlackConnection records the state of the Slack connection, e.g. "connected"
+func (bot *Bot) setSlackConnection(state string) {
+	bot.slackConnection.Store(state)
+}
+
+// statusLines describe the bot's health at now
+func (bot *Bot) statusLines(now time.Time) []string {
+	version := bot.Version
+	if version == "" {
+		version = "unknown"
+	}
+	lines := []string{fmt.Sprintf("Version: %s", version)}
+	if !bot.StartTime.IsZero() {
+		lines = append(lines, fmt.Sprintf("Uptime: %s (since %s)",
+			now.Sub(bot.StartTime).Round(time.Second), bot.StartTime.Format(time.RFC1123)))
+	}
+	lines = append(lines, fmt.Sprintf("Dev mode: %t", bot.DevMode))
+
+	slackConnection, _ := bot.slackConnection.Load().(string)
+	if slackConnection == "" {
+		slackConnection = "not started"
+	}
+	lines = append(lines, fmt.Sprintf("Slack: %s", slackConnection))
+
+	cache := bot.cache()
+	if result := bot.lastRefresh.Load(); result == nil {
+		lines = append(lines, "Last refresh: none yet")
+	} else if result.Err != nil {
+		lines = append(lines, fmt.Sprintf("Last refresh: failed at %s (%s ago): %s",
+			result.At.Format(time.RFC1123), now.Sub(result.At).Round(time.Second), result.Err))
+	} else {
+		lines = append(lines, fmt.Sprintf("Last refresh: succeeded at %s (%s ago)",
+			result.At.Format(time.RFC1123), now.Sub(result.At).Round(time.Second)))
+	}
+	if !cache.LastRefresh.IsZero() {
+		lines = append(lines, fmt.Sprintf("Team data from: %s (%s ago)",
+			cache.LastRefresh.Format(time.RFC1123), now.Sub(cache.LastRefresh).Round(time.Second)))
+	}
+
+	users := map[string]struct{}{}
+	for _, members := range cache.TeamToTeamMembers {
+		for id := range userIDSet(members) {
+			users[id] = struct{}{}
+		}
+	}
+	lines = append(lines, fmt.Sprintf("Teams: %d, users: %d", len(cache.TeamToTeamMembers), len(users)))
+
+	if bot.GithubClient != nil {
+		expiry := bot.GithubClient.TokenExpiry()
+		switch {
+		case expiry.IsZero():
+			lines = append(lines, "GitHub token: not fetched yet")
+		case expiry.Before(now):
+			lines = append(lines, fmt.Sprintf("GitHub token: expired at %s, and will be renewed on the next request",
+				expiry.Format(time.RFC1123)))
+		default:
+			lines = append(lines, fmt.Sprintf("GitHub token: expires at %s (in %s)",
+				expiry.Format(time.RFC1123), expiry.Sub(now).Round(time.Second)))
+		}
+	}
+	return lines
+}
+
+// postStatus replies with the bot's health
+func (bot *Bot) postStatus(ev *slackevents.MessageEvent) {
+	bot.Logger.InfoD("status", logger.M{"user": ev.User})
+
+	err := bot.SlackEventsService.PostMessage(ev.Channel, strings.Join(bot.statusLines(time.Now()), "\n"))
+	if err != nil {
+		bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
+	}
+}
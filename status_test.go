@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusLines(t *testing.T) {
+	mockbot, mocks, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	mockbot.Version = "v1.2.3"
+	mockbot.StartTime = now.Add(-3 * time.Hour)
+	mockbot.setSlackConnection("connected")
+	setCache(mockbot, func(c *teamCache) {
+		c.LastRefresh = now.Add(-2 * time.Hour)
+	})
+	mockbot.lastRefresh.Store(&refreshResult{At: now.Add(-time.Hour), Err: errors.New("who-is-who is down")})
+	mocks.GithubClient.EXPECT().TokenExpiry().Return(now.Add(30 * time.Minute))
+
+	assert.Equal(t, []string{
+		"Version: v1.2.3",
+		"Uptime: 3h0m0s (since Fri, 01 Mar 2024 09:00:00 UTC)",
+		"Dev mode: false",
+		"Slack: connected",
+		"Last refresh: failed at Fri, 01 Mar 2024 11:00:00 UTC (1h0m0s ago): who-is-who is down",
+		"Team data from: Fri, 01 Mar 2024 10:00:00 UTC (2h0m0s ago)",
+		"Teams: 4, users: 7",
+		"GitHub token: expires at Fri, 01 Mar 2024 12:30:00 UTC (in 30m0s)",
+	}, mockbot.statusLines(now))
+}
+
+func TestStatusCommand(t *testing.T) {
+	mockbot, mocks, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+
+	mocks.GithubClient.EXPECT().TokenExpiry().Return(time.Time{})
+	mocks.SlackEvents.EXPECT().PostMessage(testChannel, gomock.Any()).Do(func(channel, text string) {
+		assert.Contains(t, text, "Slack: not started")
+		assert.Contains(t, text, "Last refresh: none yet")
+		assert.Contains(t, text, "GitHub token: not fetched yet")
+	})
+	mockbot.DecodeMessage(makeSlackMessage("<@U1234> status"))
+}
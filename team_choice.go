@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Clever/kayvee-go/logger"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// teamChoiceActionID prefixes the action ID of every team choice button
+const teamChoiceActionID = "team-choice"
+
+// teamChoiceExpiry is how long a team choice's buttons keep working
+const teamChoiceExpiry = time.Hour
+
+const teamChoiceExpired = "Sorry, that choice has expired. Please send your command again"
+
+// pendingTeamChoice is a command waiting for the requester to pick which team they meant
+type pendingTeamChoice struct {
+	Requester string
+	Created   time.Time
+	Retry     func(team string)
+}
+
+var teamChoicesLock = &sync.Mutex{}
+
+// reportTeamLookupError tells the requester that a team name couldn't be resolved.
+// When there are teams they might have meant, it offers a button for each, and
+// clicking one calls retry with that team.
+func (bot *Bot) reportTeamLookupError(ev *slackevents.MessageEvent, err error, retry func(team string)) {
+	bot.Logger.ErrorD("find-matching-team-error", logger.M{"error": err.Error(), "event-text": ev.Text})
+
+	var lookupErr *teamLookupError
+	if !errors.As(err, &lookupErr) || len(lookupErr.Candidates)+len(lookupErr.Suggestions) == 0 {
+		err = bot.SlackEventsService.PostMessage(ev.Channel, couldNotFindTeam)
+		if err != nil {
+			bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
+		}
+		return
+	}
+
+	var text string
+	if len(lookupErr.Candidates) > 0 {
+		text = fmt.Sprintf("`%s` could be more than one team: %s. Which did you mean?", lookupErr.Name, strings.Join(lookupErr.Candidates, ", "))
+		if len(lookupErr.Suggestions) > 0 {
+			text += fmt.Sprintf(" Or did you mean %s?", strings.Join(lookupErr.Suggestions, ", "))
+		}
+	} else {
+		text = fmt.Sprintf("%s. Did you mean %s?", couldNotFindTeam, strings.Join(lookupErr.Suggestions, ", "))
+	}
+
+	choiceID := bot.addTeamChoice(pendingTeamChoice{Requester: ev.User, Created: time.Now(), Retry: retry})
+
+	buttons := []slack.BlockElement{}
+	for _, team := range lookupErr.Candidates {
+		button := slack.NewButtonBlockElement(fmt.Sprintf("%s-%d", teamChoiceActionID, len(buttons)), choiceID+"|"+team,
+			slack.NewTextBlockObject(slack.PlainTextType, team, false, false))
+		button.Style = slack.StylePrimary
+		buttons = append(buttons, button)
+	}
+	for _, team := range lookupErr.Suggestions {
+		buttons = append(buttons, slack.NewButtonBlockElement(fmt.Sprintf("%s-%d", teamChoiceActionID, len(buttons)), choiceID+"|"+team,
+			slack.NewTextBlockObject(slack.PlainTextType, team, false, false)))
+	}
+
+	err = bot.SlackEventsService.PostBlocks(ev.Channel, text,
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil),
+		slack.NewActionBlock(teamChoiceActionID, buttons...),
+	)
+	if err != nil {
+		bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
+	}
+}
+
+// addTeamChoice stores choice until it's made or expires, and returns its ID
+func (bot *Bot) addTeamChoice(choice pendingTeamChoice) string {
+	teamChoicesLock.Lock()
+	defer teamChoicesLock.Unlock()
+
+	if bot.teamChoices == nil {
+		bot.teamChoices = map[string]pendingTeamChoice{}
+		// start from the current time so buttons from before a restart don't match new choices
+		bot.lastTeamChoiceID = time.Now().UnixNano()
+	}
+	for id, c := range bot.teamChoices {
+		if time.Since(c.Created) > teamChoiceExpiry {
+			delete(bot.teamChoices, id)
+		}
+	}
+
+	bot.lastTeamChoiceID++
+	id := strconv.FormatInt(bot.lastTeamChoiceID, 10)
+	bot.teamChoices[id] = choice
+	return id
+}
+
+// IsTeamChoiceAction reports whether a Block Kit action came from a team choice button
+func IsTeamChoiceAction(action *slack.BlockAction) bool {
+	return strings.HasPrefix(action.ActionID, teamChoiceActionID)
+}
+
+// HandleTeamChoice runs the command waiting on a team choice, once its requester clicks a team
+func (bot *Bot) HandleTeamChoice(userID, channel, value string) {
+	bot.Logger.InfoD("team-choice", logger.M{"user": userID, "value": value})
+
+	parts := strings.SplitN(value, "|", 2)
+	if len(parts) != 2 {
+		bot.Logger.ErrorD("team-choice-error", logger.M{"error": "malformed value", "value": value})
+		return
+	}
+	choiceID, team := parts[0], parts[1]
+
+	teamChoicesLock.Lock()
+	choice, ok := bot.teamChoices[choiceID]
+	if ok && choice.Requester == userID {
+		delete(bot.teamChoices, choiceID)
+	}
+	teamChoicesLock.Unlock()
+
+	if !ok || time.Since(choice.Created) > teamChoiceExpiry {
+		err := bot.SlackEventsService.PostMessage(channel, teamChoiceExpired)
+		if err != nil {
+			bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
+		}
+		return
+	}
+	if choice.Requester != userID {
+		err := bot.SlackEventsService.PostMessage(channel, fmt.Sprintf("Sorry <@%s>, only <@%s> can choose the team for that command", userID, choice.Requester))
+		if err != nil {
+			bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
+		}
+		return
+	}
+
+	choice.Retry(team)
+}
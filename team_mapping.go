@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+
+	whoswho "github.com/Clever/who-is-who/go-client"
+)
+
+// teamMappingRule turns a who-is-who Team value, e.g. "Engineering - Infra", into a pickabot team name, e.g. "infra"
+type teamMappingRule struct {
+	// Prefix matches Team values that start with it. The rest of the value is the team name.
+	Prefix string `json:"prefix,omitempty"`
+	// Regex matches Team values. Its first capture group, or the whole match if it has none, is the team name.
+	Regex string `json:"regex,omitempty"`
+	// Normalize is how the team name is cleaned up:
+	// "lower-hyphen" (the default) lowercases it and replaces spaces with hyphens, "lower" only lowercases it,
+	// and "none" leaves it as is
+	Normalize string `json:"normalize,omitempty"`
+	// Namespace is prepended to the team name if set, e.g. "design" turns "ux" into "design/ux"
+	Namespace string `json:"namespace,omitempty"`
+
+	regex *regexp.Regexp
+}
+
+// defaultTeamMappingRules map "Engineering - Team Name" to "team-name"
+var defaultTeamMappingRules = func() []teamMappingRule {
+	rules := []teamMappingRule{{Regex: `^Engineering - (.+?)(?: - .*)?$`}}
+	if err := compileTeamMappingRules(rules); err != nil {
+		panic(err)
+	}
+	return rules
+}()
+
+// loadTeamMappingRules reads a JSON list of rules from path
+func loadTeamMappingRules(path string) ([]teamMappingRule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rules := []teamMappingRule{}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("error parsing team mapping rules %s: %s", path, err)
+	}
+	if err := compileTeamMappingRules(rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// compileTeamMappingRules validates rules and compiles their regexes
+func compileTeamMappingRules(rules []teamMappingRule) error {
+	for idx := range rules {
+		rule := &rules[idx]
+		if (rule.Prefix == "") == (rule.Regex == "") {
+			return fmt.Errorf("team mapping rule %d must have exactly one of prefix or regex", idx)
+		}
+		switch rule.Normalize {
+		case "", "lower-hyphen", "lower", "none":
+		default:
+			return fmt.Errorf("team mapping rule %d has unknown normalize %q", idx, rule.Normalize)
+		}
+		if rule.Regex != "" {
+			regex, err := regexp.Compile(rule.Regex)
+			if err != nil {
+				return fmt.Errorf("team mapping rule %d has an invalid regex: %s", idx, err)
+			}
+			rule.regex = regex
+		}
+	}
+	return nil
+}
+
+// mapTeam returns the pickabot team for a who-is-who Team value, using the first rule that matches it
+func mapTeam(rules []teamMappingRule, whoIsWhoTeam string) (string, bool) {
+	if len(rules) == 0 {
+		rules = defaultTeamMappingRules
+	}
+	for _, rule := range rules {
+		var team string
+		if rule.Prefix != "" {
+			if !strings.HasPrefix(whoIsWhoTeam, rule.Prefix) {
+				continue
+			}
+			team = strings.TrimPrefix(whoIsWhoTeam, rule.Prefix)
+		} else {
+			match := rule.regex.FindStringSubmatch(whoIsWhoTeam)
+			if match == nil {
+				continue
+			}
+			team = match[0]
+			if len(match) > 1 {
+				team = match[1]
+			}
+		}
+
+		team = strings.TrimSpace(team)
+		switch rule.Normalize {
+		case "", "lower-hyphen":
+			team = strings.ReplaceAll(strings.ToLower(team), " ", "-")
+		case "lower":
+			team = strings.ToLower(team)
+		}
+		if team == "" {
+			continue
+		}
+		if rule.Namespace != "" {
+			team = rule.Namespace + "/" + team
+		}
+		return team, true
+	}
+	return "", false
+}
+
+// teamMappingCollisions finds pickabot teams that more than one who-is-who team maps to,
+// returning the who-is-who teams for each. Like buildTeams, it only counts active users.
+func teamMappingCollisions(users []whoswho.User, rules []teamMappingRule) map[string][]string {
+	sources := map[string][]string{}
+	for _, u := range users {
+		if !u.Active {
+			continue
+		}
+		team, ok := mapTeam(rules, u.Team)
+		if !ok || containsString(sources[team], u.Team) {
+			continue
+		}
+		sources[team] = append(sources[team], u.Team)
+	}
+
+	collisions := map[string][]string{}
+	for team, whoIsWhoTeams := range sources {
+		if len(whoIsWhoTeams) > 1 {
+			sort.Strings(whoIsWhoTeams)
+			collisions[team] = whoIsWhoTeams
+		}
+	}
+	return collisions
+}
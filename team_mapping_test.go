@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	whoswho "github.com/Clever/who-is-who/go-client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMapTeam(t *testing.T) {
+	rules := []teamMappingRule{
+		{Prefix: "Design - ", Namespace: "design"},
+		{Regex: `^Support \((.*)\)$`, Normalize: "none"},
+		{Regex: `^Product`, Normalize: "lower"},
+	}
+	assert.NoError(t, compileTeamMappingRules(rules))
+
+	for _, test := range []struct {
+		rules        []teamMappingRule
+		whoIsWhoTeam string
+		team         string
+		ok           bool
+	}{
+		{whoIsWhoTeam: "Engineering - Infra", team: "infra", ok: true},
+		{whoIsWhoTeam: "Engineering - Data Science", team: "data-science", ok: true},
+		{whoIsWhoTeam: "Engineering - Data Science - ML", team: "data-science", ok: true},
+		{whoIsWhoTeam: "Design - UX", ok: false},
+		{rules: rules, whoIsWhoTeam: "Design - User Research", team: "design/user-research", ok: true},
+		{rules: rules, whoIsWhoTeam: "Support (Tier-2)", team: "Tier-2", ok: true},
+		{rules: rules, whoIsWhoTeam: "Product Management", team: "product", ok: true},
+		{rules: rules, whoIsWhoTeam: "Engineering - Infra", ok: false},
+		{rules: rules, whoIsWhoTeam: "Design - ", ok: false},
+	} {
+		team, ok := mapTeam(test.rules, test.whoIsWhoTeam)
+		assert.Equal(t, test.ok, ok, test.whoIsWhoTeam)
+		assert.Equal(t, test.team, team, test.whoIsWhoTeam)
+	}
+}
+
+func TestCompileTeamMappingRules(t *testing.T) {
+	assert.Error(t, compileTeamMappingRules([]teamMappingRule{{}}))
+	assert.Error(t, compileTeamMappingRules([]teamMappingRule{{Prefix: "a", Regex: "b"}}))
+	assert.Error(t, compileTeamMappingRules([]teamMappingRule{{Regex: "("}}))
+	assert.Error(t, compileTeamMappingRules([]teamMappingRule{{Prefix: "a", Normalize: "upper"}}))
+	assert.NoError(t, compileTeamMappingRules([]teamMappingRule{{Prefix: "a", Normalize: "none"}}))
+}
+
+func TestTeamMappingCollisions(t *testing.T) {
+	users := []whoswho.User{
+		{Team: "Engineering - Data Science", Active: true},
+		{Team: "Engineering - Data Science - ML", Active: true},
+		{Team: "Engineering - Data Science - ML", Active: true},
+		{Team: "Engineering - Infra", Active: true},
+		{Team: "Engineering - Infra - Old", Active: false},
+		{Team: "Sales", Active: true},
+	}
+	assert.Equal(t, map[string][]string{
+		"data-science": {"Engineering - Data Science", "Engineering - Data Science - ML"},
+	}, teamMappingCollisions(users, nil))
+}
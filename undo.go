@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Clever/kayvee-go/logger"
+	whoswho "github.com/Clever/who-is-who/go-client"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// maxUndoHistory is how many changes per user can be undone
+const maxUndoHistory = 20
+
+const nothingToUndo = "There's nothing for me to undo"
+
+// overrideChange records a user's override for a team from before a change.
+// Previous is nil if the user had no override for that team.
+type overrideChange struct {
+	SlackID  string
+	Team     string
+	Previous *Override
+}
+
+// flairChange records a user's flair from before a change
+type flairChange struct {
+	SlackID  string
+	Previous string
+}
+
+// undoEntry holds everything needed to revert a single command
+type undoEntry struct {
+	Overrides []overrideChange
+	Flair     *flairChange
+}
+
+var undoHistoryLock = &sync.Mutex{}
+
+// recordUndo remembers a change made by requesterID so it can be reverted later
+func (bot *Bot) recordUndo(requesterID string, entry undoEntry) {
+	undoHistoryLock.Lock()
+	defer undoHistoryLock.Unlock()
+
+	if bot.undoHistory == nil {
+		bot.undoHistory = map[string][]undoEntry{}
+	}
+	history := append(bot.undoHistory[requesterID], entry)
+	if len(history) > maxUndoHistory {
+		history = history[len(history)-maxUndoHistory:]
+	}
+	bot.undoHistory[requesterID] = history
+}
+
+// popUndo removes and returns the most recent change made by requesterID
+func (bot *Bot) popUndo(requesterID string) (undoEntry, bool) {
+	undoHistoryLock.Lock()
+	defer undoHistoryLock.Unlock()
+
+	history := bot.undoHistory[requesterID]
+	if len(history) == 0 {
+		return undoEntry{}, false
+	}
+	bot.undoHistory[requesterID] = history[:len(history)-1]
+	return history[len(history)-1], true
+}
+
+// undo reverts the requester's most recent override or flair change
+func (bot *Bot) undo(ev *slackevents.MessageEvent) {
+	bot.Logger.InfoD("undo", logger.M{"user": ev.User})
+
+	entry, ok := bot.popUndo(ev.User)
+	if !ok {
+		err := bot.SlackEventsService.PostMessage(ev.Channel, nothingToUndo)
+		if err != nil {
+			bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
+		}
+		return
+	}
+
+	lines := []string{}
+	failed := false
+	if err := bot.restoreTeamOverrides(entry.Overrides); err != nil {
+		bot.Logger.ErrorD("undo-save-error", logger.M{"error": err.Error(), "event-text": ev.Text})
+		lines = append(lines, fmt.Sprintf(couldNotSave, err))
+		failed = true
+	} else {
+		for _, change := range entry.Overrides {
+			line := fmt.Sprintf("Restored <@%s>'s previous membership of team %s", change.SlackID, change.Team)
+			if !containsString(lines, line) {
+				lines = append(lines, line)
+			}
+		}
+	}
+	if entry.Flair != nil {
+		if err := bot.restoreFlair(*entry.Flair); err != nil {
+			bot.Logger.ErrorD("undo-save-error", logger.M{"error": err.Error(), "event-text": ev.Text})
+			lines = append(lines, fmt.Sprintf(couldNotSave, err))
+			failed = true
+		} else {
+			lines = append(lines, fmt.Sprintf("Restored <@%s>'s previous flair", entry.Flair.SlackID))
+		}
+	}
+	// keep the change so it can be undone again. Restoring is idempotent, so parts that worked can be redone.
+	if failed {
+		bot.recordUndo(ev.User, entry)
+	}
+
+	err := bot.SlackEventsService.PostMessage(ev.Channel, strings.Join(lines, "\n"))
+	if err != nil {
+		bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
+	}
+}
+
+// restoreTeamOverrides puts back each user's previous overrides, with one directory update per user.
+// Changes are reverted newest first, so a user and team changed twice by one command get their earliest override back.
+func (bot *Bot) restoreTeamOverrides(changes []overrideChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	userIDs := []string{}
+	teamsByUser := map[string][]string{}
+	wiwOverridesByUser := map[string][]whoswho.PickabotTeamOverride{}
+	err := bot.updateCache(func(c *teamCache) error {
+		for i := len(changes) - 1; i >= 0; i-- {
+			change := changes[i]
+			for idx, o := range c.TeamOverrides {
+				if o.User.SlackID == change.SlackID && o.Team == change.Team {
+					c.TeamOverrides = append(c.TeamOverrides[:idx], c.TeamOverrides[idx+1:]...)
+					break
+				}
+			}
+			if change.Previous != nil {
+				c.TeamOverrides = append(c.TeamOverrides, *change.Previous)
+			}
+
+			if !containsString(userIDs, change.SlackID) {
+				userIDs = append(userIDs, change.SlackID)
+			}
+			if !containsString(teamsByUser[change.SlackID], change.Team) {
+				teamsByUser[change.SlackID] = append(teamsByUser[change.SlackID], change.Team)
+			}
+		}
+		for _, o := range c.TeamOverrides {
+			if containsString(teamsByUser[o.User.SlackID], o.Team) {
+				wiwOverridesByUser[o.User.SlackID] = append(wiwOverridesByUser[o.User.SlackID], whoswho.PickabotTeamOverride{
+					Team:    o.Team,
+					Include: o.Include,
+					Until:   o.Until.Unix(),
+				})
+			}
+		}
+		return bot.saveOverrides(c.TeamOverrides)
+	})
+	if err != nil {
+		return err
+	}
+	for _, userID := range userIDs {
+		bot.setTeamOverridesInDirectory(userID, teamsByUser[userID], wiwOverridesByUser[userID])
+	}
+	return nil
+}
+
+func (bot *Bot) restoreFlair(change flairChange) error {
+	err := bot.updateCache(func(c *teamCache) error {
+		if change.Previous == "" {
+			delete(c.UserFlair, change.SlackID)
+		} else {
+			c.UserFlair[change.SlackID] = change.Previous
+		}
+		return bot.saveFlair(c.UserFlair)
+	})
+	if err != nil {
+		return err
+	}
+	bot.updateFlairInDirectory(change.SlackID, change.Previous)
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Clever/kayvee-go/logger"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// describeUserTeams replies with everything pickabot knows about a user's teams:
+// their who-is-who team, their overrides, their flair and the GitHub login they'd be assigned as
+func (bot *Bot) describeUserTeams(ev *slackevents.MessageEvent, slackID string) {
+	bot.Logger.InfoD("describe-user-teams", logger.M{"user": slackID, "current-user": ev.User})
+
+	cache := bot.cache()
+	lines := []string{}
+
+	// Official teams, as known by who-is-who
+	officialTeams := []string{}
+	github := ""
+	for team, members := range cache.TeamToTeamMembers {
+		for _, u := range members {
+			if u.SlackID == slackID {
+				officialTeams = append(officialTeams, team)
+				if u.Github != "" {
+					github = u.Github
+				}
+			}
+		}
+	}
+	sort.Strings(officialTeams)
+	if len(officialTeams) > 0 {
+		lines = append(lines, fmt.Sprintf("<@%s> is on %s in who-is-who", slackID, teamsPhrase(officialTeams)))
+	} else {
+		lines = append(lines, fmt.Sprintf("<@%s> isn't on a team in who-is-who", slackID))
+	}
+
+	// Overrides
+	added, removed := []string{}, []string{}
+	for _, o := range cache.TeamOverrides {
+		if o.User.SlackID != slackID {
+			continue
+		}
+		if o.User.Github != "" && github == "" {
+			github = o.User.Github
+		}
+		team := o.Team
+		if !o.Until.IsZero() {
+			team = fmt.Sprintf("%s (until %s)", team, o.Until.Format("2006-01-02"))
+		}
+		if o.Include {
+			added = append(added, team)
+		} else {
+			removed = append(removed, team)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	if len(added) > 0 {
+		lines = append(lines, fmt.Sprintf("Added to %s", teamsPhrase(added)))
+	}
+	if len(removed) > 0 {
+		lines = append(lines, fmt.Sprintf("Removed from %s", teamsPhrase(removed)))
+	}
+
+	// Composite teams they end up on
+	composites := []string{}
+	for _, composite := range bot.compositeTeams() {
+		for _, u := range bot.buildTeam(composite) {
+			if u.SlackID == slackID {
+				composites = append(composites, composite)
+				break
+			}
+		}
+	}
+	if len(composites) > 0 {
+		lines = append(lines, fmt.Sprintf("Through composite teams, also on %s", teamsPhrase(composites)))
+	}
+
+	flair := cache.UserFlair[slackID]
+	if flair != "" {
+		lines = append(lines, fmt.Sprintf("Flair: %s", flair))
+	}
+
+	// GitHub login, looked up the same way as when assigning PRs
+	if github == "" {
+		user, err := bot.Directory.UserBySlackID(slackID)
+		if err != nil {
+			bot.Logger.ErrorD("describe-user-teams-wiw-error", logger.M{"user": slackID, "error": err.Error()})
+		}
+		github = user.Github
+	}
+	if github != "" {
+		lines = append(lines, fmt.Sprintf("GitHub: %s", github))
+	} else {
+		lines = append(lines, "GitHub: none, so I can't assign them pull requests")
+	}
+
+	err := bot.SlackEventsService.PostMessage(ev.Channel, strings.Join(lines, "\n"))
+	if err != nil {
+		bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
+	}
+}
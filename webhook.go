@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/Clever/kayvee-go/logger"
+	whoswho "github.com/Clever/who-is-who/go-client"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// webhookLabelPrefix starts the labels that ask pickabot for a reviewer, e.g. "pickabot:infra"
+const webhookLabelPrefix = "pickabot:"
+
+// maxWebhookBody limits how much of a webhook request is read. GitHub sends at most 25MB, but
+// pull_request payloads are much smaller.
+const maxWebhookBody = 5 << 20
+
+// maxWebhookDeliveries is how many recent webhook delivery IDs are remembered, to ignore redeliveries
+const maxWebhookDeliveries = 1000
+
+// pullRequestEvent is the part of a GitHub pull_request webhook payload that pickabot uses
+type pullRequestEvent struct {
+	Action string `json:"action"`
+	Label  struct {
+		Name string `json:"name"`
+	} `json:"label"`
+	// RequestedTeam is set when a review is requested from a team
+	RequestedTeam struct {
+		Slug string `json:"slug"`
+	} `json:"requested_team"`
+	PullRequest struct {
+		Number  int    `json:"number"`
+		HTMLURL string `json:"html_url"`
+		User    struct {
+			Login string `json:"login"`
+		} `json:"user"`
+	} `json:"pull_request"`
+	Repository struct {
+		Name  string `json:"name"`
+		Owner struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+	} `json:"repository"`
+}
+
+func (e pullRequestEvent) pr() githubPR {
+	return githubPR{Owner: e.Repository.Owner.Login, Repo: e.Repository.Name, PRNumber: e.PullRequest.Number}
+}
+
+// validSignature checks an X-Hub-Signature-256 header, "sha256=<hex HMAC of body>", against secret
+func validSignature(body []byte, header string, secret []byte) bool {
+	if len(secret) == 0 || !strings.HasPrefix(header, "sha256=") {
+		return false
+	}
+	signature, err := hex.DecodeString(strings.TrimPrefix(header, "sha256="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hmac.Equal(signature, mac.Sum(nil))
+}
+
+// HandleGithubWebhook receives GitHub webhooks. When a pull request is labeled "pickabot:<team>", or a
+// review is requested from a GitHub team, it picks someone from the team to review it.
+func (bot *Bot) HandleGithubWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+	if err != nil {
+		http.Error(w, "error reading body", http.StatusBadRequest)
+		return
+	}
+	if !validSignature(body, r.Header.Get("X-Hub-Signature-256"), bot.WebhookSecret) {
+		bot.Logger.WarnD("webhook-bad-signature", logger.M{"delivery": r.Header.Get("X-GitHub-Delivery")})
+		http.Error(w, "bad signature", http.StatusUnauthorized)
+		return
+	}
+
+	eventType := r.Header.Get("X-GitHub-Event")
+	bot.Logger.InfoD("webhook-received", logger.M{"event": eventType, "delivery": r.Header.Get("X-GitHub-Delivery")})
+	if eventType != "pull_request" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	event := pullRequestEvent{}
+	if err := json.Unmarshal(body, &event); err != nil {
+		bot.Logger.ErrorD("webhook-parse-error", logger.M{"error": err.Error()})
+		http.Error(w, "error parsing payload", http.StatusBadRequest)
+		return
+	}
+	delivery := r.Header.Get("X-GitHub-Delivery")
+	if bot.seenWebhookDelivery(delivery) {
+		bot.Logger.InfoD("webhook-redelivery", logger.M{"delivery": delivery})
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	// GitHub gives up on a webhook after 10 seconds and redelivers it, and picking can wait longer than
+	// that on GitHub's rate limits, so respond before picking
+	w.WriteHeader(http.StatusAccepted)
+	bot.webhooks.Add(1)
+	go func() {
+		defer bot.webhooks.Done()
+		bot.handlePullRequestEvent(event)
+	}()
+}
+
+var webhookDeliveriesLock = &sync.Mutex{}
+
+// seenWebhookDelivery reports whether a webhook with this delivery ID was already received, and remembers it
+// if not. Only the most recent maxWebhookDeliveries are remembered.
+func (bot *Bot) seenWebhookDelivery(delivery string) bool {
+	if delivery == "" {
+		return false
+	}
+	webhookDeliveriesLock.Lock()
+	defer webhookDeliveriesLock.Unlock()
+
+	if bot.webhookDeliveries == nil {
+		bot.webhookDeliveries = map[string]struct{}{}
+	}
+	if _, ok := bot.webhookDeliveries[delivery]; ok {
+		return true
+	}
+	bot.webhookDeliveries[delivery] = struct{}{}
+	bot.webhookDeliveryOrder = append(bot.webhookDeliveryOrder, delivery)
+	if len(bot.webhookDeliveryOrder) > maxWebhookDeliveries {
+		delete(bot.webhookDeliveries, bot.webhookDeliveryOrder[0])
+		bot.webhookDeliveryOrder = bot.webhookDeliveryOrder[1:]
+	}
+	return false
+}
+
+// handlePullRequestEvent picks reviewers for pull requests in the bot's orgs that get a pickabot label,
+// or that have a review requested from a GitHub team
+func (bot *Bot) handlePullRequestEvent(event pullRequestEvent) {
+	labeled := event.Action == "labeled" && strings.HasPrefix(event.Label.Name, webhookLabelPrefix)
+	teamRequested := event.Action == "review_requested" && event.RequestedTeam.Slug != ""
+	if !labeled && !teamRequested {
+		return
+	}
+	if !containsFold(bot.GithubOrgNames, event.Repository.Owner.Login) {
+		bot.Logger.WarnD("webhook-other-org", logger.M{"org": event.Repository.Owner.Login, "repo": event.Repository.Name})
+		return
+	}
+
+	if teamRequested {
+		bot.replaceTeamReviewRequest(event)
+		return
+	}
+
+	teamName := strings.TrimPrefix(event.Label.Name, webhookLabelPrefix)
+	reason := fmt.Sprintf("Label `%s` on %s", event.Label.Name, event.PullRequest.HTMLURL)
+	team, err := bot.findMatchingTeam(teamName)
+	if err != nil {
+		bot.Logger.WarnD("webhook-team-error", logger.M{"team": teamName, "error": err.Error()})
+		bot.postWebhookResult(teamSettings{}, fmt.Sprintf("%s: I couldn't find team %s: %s", reason, teamName, err))
+		return
+	}
+	bot.pickForPullRequest(event, team, reason, false)
+}
+
+// replaceTeamReviewRequest passes a review requested from a GitHub team on to one of the matching team's members,
+// then removes the team's request. The GitHub team matches the team whose github-team setting is its slug, or
+// else the team with its slug as name or alias. Requests from GitHub teams that match no team are left alone.
+func (bot *Bot) replaceTeamReviewRequest(event pullRequestEvent) {
+	slug := event.RequestedTeam.Slug
+	team, ok := bot.teamForGithubTeam(slug)
+	if !ok {
+		// no fuzzy matching, since the GitHub team's request is removed
+		team, ok = bot.teamNamed(slug)
+	}
+	if !ok {
+		bot.Logger.InfoD("webhook-unknown-github-team", logger.M{"github-team": slug})
+		return
+	}
+
+	reason := fmt.Sprintf("Review requested from GitHub team %s on %s", slug, event.PullRequest.HTMLURL)
+	if err := bot.pickForPullRequest(event, team, reason, true); err != nil || bot.DevMode {
+		return
+	}
+
+	pr := event.pr()
+	_, err := bot.GithubClient.RemoveTeamReviewers(context.Background(), pr.Owner, pr.Repo, pr.PRNumber, []string{slug})
+	if err != nil {
+		bot.Logger.ErrorD("remove-team-reviewer-error", logger.M{"error": err.Error(), "repo": pr.Repo, "github-team": slug})
+		bot.postWebhookResult(bot.teamSettings(team), fmt.Sprintf("%s: I couldn't remove the review request for GitHub team %s: %s", reason, slug, err))
+	}
+}
+
+// pickForPullRequest picks members of team to review the event's pull request, other than its author.
+// The result is posted to WebhookChannel and the team's channel, starting with reason.
+// If needsReviewer is set, the picked members are asked for reviews even if the team only assigns.
+func (bot *Bot) pickForPullRequest(event pullRequestEvent, team, reason string, needsReviewer bool) error {
+	url, author := event.PullRequest.HTMLURL, event.PullRequest.User.Login
+	bot.Logger.InfoD("webhook-pick", logger.M{"team": team, "pr": url, "author": author})
+
+	members := bot.buildTeam(team)
+	settings := bot.teamSettings(team)
+	// the dev bot shouldn't hit the API
+	var warning string
+	if !bot.DevMode {
+		var skip string
+		skip, warning = bot.checkPullRequest(event.pr())
+		if skip != "" {
+			bot.postWebhookResult(settings, fmt.Sprintf("%s: I skipped it: %s", reason, skip))
+			return errors.New(skip)
+		}
+	}
+	var omit *whoswho.User
+	if !settings.IncludeRequester {
+		for _, u := range members {
+			if u.Github != "" && strings.EqualFold(u.Github, author) {
+				omit = &u
+				break
+			}
+		}
+	}
+
+	users, err := pickUsers(members, omit, settings.reviewers(), settings.strategy(), bot.lastPicked(), bot.RandomSource)
+	if err != nil {
+		bot.Logger.ErrorD("pick-user-error", logger.M{"error": err.Error(), "pr": url})
+		bot.postWebhookResult(settings, fmt.Sprintf("%s: %s", reason, pickUserProblem))
+		return err
+	}
+	bot.recordPicks(users)
+
+	assign := settings.assign()
+	if needsReviewer && assign == assignAssignee {
+		assign = assignBoth
+	}
+	mentions := bot.userMentions(users)
+	err = bot.assignPullRequest(nil, event.pr(), users, assign)
+	if err != nil {
+		bot.postWebhookResult(settings, fmt.Sprintf("%s: error setting %s as pull-request reviewer: %s", reason, mentions, err))
+		return err
+	}
+	text := fmt.Sprintf("%s: set %s from team %s as pull-request reviewer", reason, mentions, team)
+	if warning != "" {
+		text += "\n" + warning
+	}
+	bot.postWebhookResult(settings, text)
+	return nil
+}
+
+// assignPullRequest assigns users to, and/or requests their reviews on, pr, as assign says. ev is the Slack
+// message that asked for it, or nil for a webhook. The caller checks pr with checkPullRequest first.
+func (bot *Bot) assignPullRequest(ev *slackevents.MessageEvent, pr githubPR, users []whoswho.User, assign string) error {
+	// githubUser logs the message that asked for users, so give it one with the pull request in it
+	lookupEv := ev
+	if lookupEv == nil {
+		lookupEv = &slackevents.MessageEvent{Text: pullRequestURL(bot.githubHost(), pr)}
+	}
+	logins := []string{}
+	for _, u := range users {
+		user, err := bot.githubUser(lookupEv, u)
+		if err != nil {
+			return err
+		}
+		logins = append(logins, user.Github)
+	}
+
+	// the dev bot shouldn't hit the API
+	if bot.DevMode {
+		text := fmt.Sprintf("would have assigned %s to %s", strings.Join(logins, ", "), pr.Repo)
+		if ev == nil {
+			bot.postWebhookResult(teamSettings{}, text)
+		} else if err := bot.SlackEventsService.PostMessage(ev.Channel, text); err != nil {
+			bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
+		}
+		return nil
+	}
+	if assign != assignReviewer {
+		_, _, err := bot.GithubClient.AddAssignees(context.Background(), pr.Owner, pr.Repo, pr.PRNumber, logins)
+		if err != nil {
+			bot.Logger.ErrorD("set-assignee-failure-warning", logger.M{"warning": err.Error(), "repo": pr.Repo, "user": logins})
+			return fmt.Errorf("GitHub wouldn't assign them: %s", err)
+		}
+	}
+	if assign != assignAssignee {
+		_, _, err := bot.GithubClient.AddReviewers(context.Background(), pr.Owner, pr.Repo, pr.PRNumber, logins)
+		if err != nil {
+			bot.Logger.ErrorD("set-reviewer-failure-warning", logger.M{"warning": err.Error(), "repo": pr.Repo, "user": logins})
+			return fmt.Errorf("GitHub wouldn't request their reviews: %s", err)
+		}
+	}
+	bot.Logger.InfoD("set-assignee-success", logger.M{"repo": pr.Repo, "pr": pr.PRNumber, "user": logins, "assign": assign})
+	return nil
+}
+
+// postWebhookResult posts text to WebhookChannel and the team's channel, if they're set
+func (bot *Bot) postWebhookResult(settings teamSettings, text string) {
+	channels := []string{bot.WebhookChannel}
+	if settings.Channel != bot.WebhookChannel {
+		channels = append(channels, settings.Channel)
+	}
+	for _, channel := range channels {
+		if channel == "" {
+			continue
+		}
+		err := bot.SlackEventsService.PostMessage(channel, text)
+		if err != nil {
+			bot.Logger.ErrorD("message-error", logger.M{"error": err.Error()})
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	pickabotgithub "github.com/Clever/pickabot/github"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+var testWebhookSecret = []byte("webhook-secret")
+
+func signWebhook(body, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// postWebhook sends the recorded payload in testdata/webhooks to server, as a new delivery
+func postWebhook(t *testing.T, server *httptest.Server, event, payload, signature string) *http.Response {
+	return redeliverWebhook(t, server, event, payload, signature, "")
+}
+
+// redeliverWebhook is postWebhook with a delivery ID, which is random if it's empty
+func redeliverWebhook(t *testing.T, server *httptest.Server, event, payload, signature, delivery string) *http.Response {
+	body, err := ioutil.ReadFile(filepath.Join("testdata", "webhooks", payload))
+	assert.NoError(t, err)
+	if signature == "" {
+		signature = signWebhook(body, testWebhookSecret)
+	}
+
+	req, err := http.NewRequest("POST", server.URL+"/github/webhook", bytes.NewReader(body))
+	assert.NoError(t, err)
+	req.Header.Set("X-GitHub-Event", event)
+	req.Header.Set("X-Hub-Signature-256", signature)
+	if delivery == "" {
+		delivery = fmt.Sprintf("delivery-%d", rand.Int63())
+	}
+	req.Header.Set("X-GitHub-Delivery", delivery)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	return resp
+}
+
+func TestValidSignature(t *testing.T) {
+	body := []byte(`{"action":"labeled"}`)
+	assert.True(t, validSignature(body, signWebhook(body, testWebhookSecret), testWebhookSecret))
+	assert.False(t, validSignature(body, signWebhook(body, []byte("other-secret")), testWebhookSecret))
+	assert.False(t, validSignature([]byte(`{"action":"closed"}`), signWebhook(body, testWebhookSecret), testWebhookSecret))
+	assert.False(t, validSignature(body, "sha1=abc", testWebhookSecret))
+	assert.False(t, validSignature(body, "sha256=not-hex", testWebhookSecret))
+	assert.False(t, validSignature(body, signWebhook(body, nil), nil), "no secret means no webhook is valid")
+}
+
+func TestGithubWebhook(t *testing.T) {
+	mockbot, mocks, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+	mockbot.WebhookSecret = testWebhookSecret
+	mockbot.WebhookChannel = "reviews"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/github/webhook", mockbot.HandleGithubWebhook)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	t.Log("Badly signed webhooks are rejected")
+	resp := postWebhook(t, server, "pull_request", "pull_request_labeled.json", "sha256=0000")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	t.Log("Other events are ignored")
+	resp = postWebhook(t, server, "ping", "ping.json", "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	t.Log("A pickabot label picks someone other than the PR's author")
+	gomock.InOrder(
+		mocks.GithubClient.EXPECT().GetPullRequestStatus(gomock.Any(), testGithubOrg, "fake-repo", 42).Return(testOpenPR, nil),
+		mocks.GithubClient.EXPECT().AddAssignees(gomock.Any(), testGithubOrg, "fake-repo", 42, []string{"G2Github"}),
+		mocks.GithubClient.EXPECT().AddReviewers(gomock.Any(), testGithubOrg, "fake-repo", 42, []string{"G2Github"}),
+		mocks.SlackEvents.EXPECT().PostMessage("reviews", "Label `pickabot:github-user-team` on https://github.com/Clever/fake-repo/pull/42: "+
+			"set <@G2> from team github-user-team as pull-request reviewer"),
+	)
+	resp = redeliverWebhook(t, server, "pull_request", "pull_request_labeled.json", "", "labeled-1")
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	mockbot.webhooks.Wait()
+
+	t.Log("Redeliveries are ignored")
+	resp = redeliverWebhook(t, server, "pull_request", "pull_request_labeled.json", "", "labeled-1")
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	mockbot.webhooks.Wait()
+}
+
+func TestSeenWebhookDelivery(t *testing.T) {
+	mockbot, _, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+
+	assert.False(t, mockbot.seenWebhookDelivery("first"))
+	assert.True(t, mockbot.seenWebhookDelivery("first"))
+	assert.False(t, mockbot.seenWebhookDelivery(""), "webhooks without a delivery ID are never duplicates")
+	assert.False(t, mockbot.seenWebhookDelivery(""))
+
+	t.Log("Only recent deliveries are remembered")
+	for i := 0; i < maxWebhookDeliveries; i++ {
+		mockbot.seenWebhookDelivery(fmt.Sprintf("delivery-%d", i))
+	}
+	assert.False(t, mockbot.seenWebhookDelivery("first"))
+	assert.True(t, mockbot.seenWebhookDelivery(fmt.Sprintf("delivery-%d", maxWebhookDeliveries-1)))
+}
+
+func TestPullRequestEventChecksState(t *testing.T) {
+	mockbot, mocks, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+	mockbot.WebhookChannel = "reviews"
+
+	event := pullRequestEvent{Action: "labeled"}
+	event.Label.Name = "pickabot:github-user-team"
+	event.Repository.Owner.Login = testGithubOrg
+	event.Repository.Name = "fake-repo"
+	event.PullRequest.Number = 1
+	event.PullRequest.HTMLURL = "https://github.com/Clever/fake-repo/pull/1"
+
+	t.Log("Closed pull requests are skipped, without picking anyone")
+	gomock.InOrder(
+		mocks.GithubClient.EXPECT().GetPullRequestStatus(gomock.Any(), testGithubOrg, "fake-repo", 1).
+			Return(pickabotgithub.PullRequestStatus{State: "closed"}, nil),
+		mocks.SlackEvents.EXPECT().PostMessage("reviews", "Label `pickabot:github-user-team` on https://github.com/Clever/fake-repo/pull/1: "+
+			"I skipped it: it's closed"),
+	)
+	mockbot.handlePullRequestEvent(event)
+	assert.Empty(t, mockbot.lastPicked())
+
+	t.Log("Drafts are assigned with a warning")
+	gomock.InOrder(
+		mocks.GithubClient.EXPECT().GetPullRequestStatus(gomock.Any(), testGithubOrg, "fake-repo", 1).
+			Return(pickabotgithub.PullRequestStatus{State: "open", Draft: true}, nil),
+		mocks.GithubClient.EXPECT().AddAssignees(gomock.Any(), testGithubOrg, "fake-repo", 1, gomock.Any()),
+		mocks.GithubClient.EXPECT().AddReviewers(gomock.Any(), testGithubOrg, "fake-repo", 1, gomock.Any()),
+		mocks.SlackEvents.EXPECT().PostMessage("reviews", gomock.Any()).Do(func(channel, text string) {
+			assert.Contains(t, text, "\nhttps://github.com/Clever/fake-repo/pull/1 is still a draft, so it may not be ready for review yet")
+		}),
+	)
+	mockbot.handlePullRequestEvent(event)
+}
+
+func TestPullRequestEventIgnored(t *testing.T) {
+	mockbot, _, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+
+	event := pullRequestEvent{Action: "labeled"}
+	event.Label.Name = "pickabot:example-team"
+	event.Repository.Owner.Login = "SomeoneElse"
+	mockbot.handlePullRequestEvent(event)
+
+	event.Repository.Owner.Login = testGithubOrg
+	event.Label.Name = "bug"
+	mockbot.handlePullRequestEvent(event)
+
+	event.Label.Name = "pickabot:example-team"
+	event.Action = "unlabeled"
+	mockbot.handlePullRequestEvent(event)
+}
+
+func TestPullRequestEventUnknownTeam(t *testing.T) {
+	mockbot, mocks, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+	mockbot.WebhookChannel = "reviews"
+
+	event := pullRequestEvent{Action: "labeled"}
+	event.Label.Name = "pickabot:nonexistent"
+	event.Repository.Owner.Login = testGithubOrg
+	event.PullRequest.HTMLURL = "https://github.com/Clever/fake-repo/pull/1"
+	mocks.SlackEvents.EXPECT().PostMessage("reviews", "Label `pickabot:nonexistent` on https://github.com/Clever/fake-repo/pull/1: "+
+		"I couldn't find team nonexistent: no team with that name was found")
+	mockbot.handlePullRequestEvent(event)
+}
+
+func TestGithubWebhookTeamReviewRequest(t *testing.T) {
+	mockbot, mocks, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+	mockbot.WebhookSecret = testWebhookSecret
+	mockbot.WebhookChannel = "reviews"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/github/webhook", mockbot.HandleGithubWebhook)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	t.Log("Requests from GitHub teams that match no team are left alone")
+	resp := postWebhook(t, server, "pull_request", "pull_request_review_requested_team.json", "")
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	mockbot.webhooks.Wait()
+
+	t.Log("A team with the GitHub team's slug as its github-team reviews instead, even if it only assigns")
+	mockbot.State.TeamSettings = map[string]teamSettings{
+		"github-user-team": {GithubTeam: "reviewers-of-things", Assign: assignAssignee},
+	}
+	gomock.InOrder(
+		mocks.GithubClient.EXPECT().GetPullRequestStatus(gomock.Any(), testGithubOrg, "fake-repo", 42).Return(testOpenPR, nil),
+		mocks.GithubClient.EXPECT().AddAssignees(gomock.Any(), testGithubOrg, "fake-repo", 42, []string{"G2Github"}),
+		mocks.GithubClient.EXPECT().AddReviewers(gomock.Any(), testGithubOrg, "fake-repo", 42, []string{"G2Github"}),
+		mocks.SlackEvents.EXPECT().PostMessage("reviews", "Review requested from GitHub team reviewers-of-things on https://github.com/Clever/fake-repo/pull/42: "+
+			"set <@G2> from team github-user-team as pull-request reviewer"),
+		mocks.GithubClient.EXPECT().RemoveTeamReviewers(gomock.Any(), testGithubOrg, "fake-repo", 42, []string{"reviewers-of-things"}),
+	)
+	resp = postWebhook(t, server, "pull_request", "pull_request_review_requested_team.json", "")
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	mockbot.webhooks.Wait()
+}
+
+func TestTeamReviewRequestNearMissIgnored(t *testing.T) {
+	mockbot, _, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+	mockbot.WebhookChannel = "reviews"
+
+	// xgithub-user-team is one letter from github-user-team, but only exact names and aliases match,
+	// so nobody is picked and the request isn't removed
+	event := pullRequestEvent{Action: "review_requested"}
+	event.RequestedTeam.Slug = "xgithub-user-team"
+	event.Repository.Owner.Login = testGithubOrg
+	event.Repository.Name = "fake-repo"
+	event.PullRequest.Number = 1
+	event.PullRequest.HTMLURL = "https://github.com/Clever/fake-repo/pull/1"
+	mockbot.handlePullRequestEvent(event)
+
+	t.Log("Aliases match exactly")
+	mockbot.State.Aliases = map[string]string{"xgithub-user-team": "github-user-team"}
+	team, ok := mockbot.teamNamed("xgithub-user-team")
+	assert.True(t, ok)
+	assert.Equal(t, "github-user-team", team)
+}
+
+func TestTeamReviewRequestKeptWhenReviewerNotSet(t *testing.T) {
+	mockbot, mocks, mockCtrl := getMockBot(t)
+	defer mockCtrl.Finish()
+	mockbot.WebhookChannel = "reviews"
+	mockbot.State.TeamSettings = map[string]teamSettings{
+		"github-user-team": {Assign: assignReviewer},
+	}
+
+	event := pullRequestEvent{Action: "review_requested"}
+	event.RequestedTeam.Slug = "github-user-team"
+	event.Repository.Owner.Login = testGithubOrg
+	event.Repository.Name = "fake-repo"
+	event.PullRequest.Number = 1
+	event.PullRequest.HTMLURL = "https://github.com/Clever/fake-repo/pull/1"
+	event.PullRequest.User.Login = "github"
+
+	gomock.InOrder(
+		mocks.GithubClient.EXPECT().GetPullRequestStatus(gomock.Any(), testGithubOrg, "fake-repo", 1).Return(testOpenPR, nil),
+		mocks.GithubClient.EXPECT().AddReviewers(gomock.Any(), testGithubOrg, "fake-repo", 1, []string{"G2Github"}).
+			Return(nil, nil, errors.New("not a collaborator")),
+		mocks.SlackEvents.EXPECT().PostMessage("reviews", "Review requested from GitHub team github-user-team on https://github.com/Clever/fake-repo/pull/1: "+
+			"error setting <@G2> as pull-request reviewer: GitHub wouldn't request their reviews: not a collaborator"),
+	)
+	mockbot.handlePullRequestEvent(event)
+}